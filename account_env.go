@@ -0,0 +1,182 @@
+package uotp
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// The environment variables AccountFromEnv reads.
+const (
+	// EnvAccount holds the whole account, as JSON or as a URI of the form
+	// uotp://SERIAL?oid=OID&id=ID&seed=SEED.
+	EnvAccount = "UOTP_ACCOUNT"
+
+	EnvOID    = "UOTP_OID"
+	EnvSeed   = "UOTP_SEED"
+	EnvID     = "UOTP_ID"
+	EnvSerial = "UOTP_SERIAL"
+)
+
+// ErrNoEnvAccount is returned by AccountFromEnv when none of its variables
+// is set.
+var ErrNoEnvAccount = errors.New("no account in the environment")
+
+// accountURIScheme is the scheme of the URI form of EnvAccount.
+const accountURIScheme = "uotp://"
+
+// AccountFromEnv builds an account from EnvAccount, or else from EnvOID,
+// EnvSeed, EnvID and EnvSerial, for environments such as CI where secrets
+// are given as variables rather than files.
+//
+// The account has no time offset; synchronize it before generating tokens.
+// Without a device profile, one is picked from the serial number so that it
+// stays the same from run to run.
+//
+// Errors name the variables but never hold their values.
+func AccountFromEnv() (*Account, error) {
+	return accountFromEnv(os.Getenv)
+}
+
+func accountFromEnv(getenv func(string) string) (*Account, error) {
+	var account *Account
+	var err error
+
+	if s := strings.TrimSpace(getenv(EnvAccount)); s != "" {
+		if strings.HasPrefix(s, "{") {
+			account, err = parseAccountJSON(s)
+		} else {
+			account, err = ParseAccountURI(s)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", EnvAccount, err)
+		}
+	} else {
+		account = &Account{
+			OID:          strings.TrimSpace(getenv(EnvOID)),
+			Seed:         strings.TrimSpace(getenv(EnvSeed)),
+			ID:           strings.TrimSpace(getenv(EnvID)),
+			SerialNumber: strings.TrimSpace(getenv(EnvSerial)),
+		}
+		if *account == (Account{}) {
+			return nil, ErrNoEnvAccount
+		}
+
+		var missing []string
+		for _, v := range []struct{ name, value string }{
+			{EnvOID, account.OID},
+			{EnvSeed, account.Seed},
+			{EnvID, account.ID},
+			{EnvSerial, account.SerialNumber},
+		} {
+			if v.value == "" {
+				missing = append(missing, v.name)
+			}
+		}
+		if len(missing) > 0 {
+			return nil, fmt.Errorf("%w: %s not set", ErrInvalidAccount, strings.Join(missing, ", "))
+		}
+		if err = checkAccount(account); err != nil {
+			return nil, err
+		}
+	}
+
+	if account.Device == nil {
+		device := serialDeviceProfile(account.SerialNumber)
+		account.Device = &device
+	}
+	return account, nil
+}
+
+// parseAccountJSON decodes an account written by GetAccount. json errors
+// may quote the input, so they are not returned.
+func parseAccountJSON(s string) (*Account, error) {
+	var account Account
+	if err := json.Unmarshal([]byte(s), &account); err != nil {
+		return nil, fmt.Errorf("%w: not valid JSON", ErrInvalidAccount)
+	}
+	if account.ID == "" || account.OID == "" || account.Seed == "" || account.SerialNumber == "" {
+		return nil, fmt.Errorf("%w: id, oid, seed and serial_number are needed", ErrInvalidAccount)
+	}
+	return &account, checkAccount(&account)
+}
+
+// ParseAccountURI parses an account of the form
+// uotp://SERIAL?oid=OID&id=ID&seed=SEED. The seed is in base64, and may be
+// written with or without escaping.
+func ParseAccountURI(s string) (*Account, error) {
+	if !strings.HasPrefix(s, accountURIScheme) {
+		return nil, fmt.Errorf("%w: the URI does not start with %s", ErrInvalidAccount, accountURIScheme)
+	}
+	serial, query, _ := strings.Cut(strings.TrimPrefix(s, accountURIScheme), "?")
+
+	account := &Account{
+		SerialNumber: strings.TrimSuffix(serial, "/"),
+	}
+	// url.ParseQuery would read the "+" of an unescaped seed as a space.
+	for _, param := range strings.Split(query, "&") {
+		key, value, _ := strings.Cut(param, "=")
+		value, err := url.PathUnescape(value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: badly escaped %s", ErrInvalidAccount, key)
+		}
+		switch key {
+		case "oid":
+			account.OID = value
+		case "id":
+			account.ID = value
+		case "seed":
+			account.Seed = value
+		}
+	}
+
+	if account.SerialNumber == "" || account.OID == "" || account.ID == "" || account.Seed == "" {
+		return nil, fmt.Errorf("%w: the URI needs a serial number, oid, id and seed", ErrInvalidAccount)
+	}
+	return account, checkAccount(account)
+}
+
+// checkAccount checks what New would reject, naming the field only.
+func checkAccount(account *Account) error {
+	if _, err := strconv.ParseUint(account.OID, 10, 64); err != nil {
+		return fmt.Errorf("%w: the oid is not a number", ErrInvalidAccount)
+	}
+	if _, err := base64.StdEncoding.DecodeString(account.Seed); err != nil {
+		return fmt.Errorf("%w: the seed is not base64", ErrInvalidAccount)
+	}
+	return nil
+}
+
+// serialDeviceProfile picks a device profile from the serial number.
+func serialDeviceProfile(serial string) DeviceProfile {
+	h := fnv.New32a()
+	h.Write([]byte(normalizeSerial(serial)))
+	return DefaultDeviceProfiles[h.Sum32()%uint32(len(DefaultDeviceProfiles))]
+}
+
+// String describes the account without its ID and seed, so that it can be
+// logged.
+func (a Account) String() string {
+	return fmt.Sprintf("Account{SerialNumber: %s, OID: %s}", a.SerialNumber, a.OID)
+}
+
+// GoString is like String, for %#v.
+func (a Account) GoString() string {
+	return a.String()
+}
+
+// String describes the account like Account.String, with its label.
+func (a KeyringAccount) String() string {
+	return fmt.Sprintf("%s: %s", a.Label, a.Account)
+}
+
+// GoString is like String, for %#v.
+func (a KeyringAccount) GoString() string {
+	return a.String()
+}
@@ -0,0 +1,102 @@
+package uotp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func envFunc(env map[string]string) func(string) string {
+	return func(key string) string {
+		return env[key]
+	}
+}
+
+func TestAccountFromEnv(t *testing.T) {
+	want := testAccount
+	want.Device = nil
+
+	data, err := json.Marshal(&want)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name string
+		env  map[string]string
+	}{
+		{"variables", map[string]string{
+			EnvOID:    want.OID,
+			EnvSeed:   want.Seed,
+			EnvID:     want.ID,
+			EnvSerial: want.SerialNumber,
+		}},
+		{"json", map[string]string{EnvAccount: string(data)}},
+		{"uri", map[string]string{EnvAccount: fmt.Sprintf("uotp://%s?oid=%s&id=%s&seed=%s",
+			want.SerialNumber, want.OID, want.ID, url.QueryEscape(want.Seed))}},
+		{"unescaped uri", map[string]string{EnvAccount: fmt.Sprintf("uotp://%s?oid=%s&id=%s&seed=%s",
+			want.SerialNumber, want.OID, want.ID, want.Seed)}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			a, err := accountFromEnv(envFunc(c.env))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if a.ID != want.ID || a.OID != want.OID || a.Seed != want.Seed || a.SerialNumber != want.SerialNumber {
+				t.Errorf("got %+v", *a)
+			}
+			if a.Device == nil || *a.Device != serialDeviceProfile(want.SerialNumber) {
+				t.Errorf("device %v, want the one of the serial number", a.Device)
+			}
+			if _, err = New(a); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+
+	if _, err = accountFromEnv(envFunc(nil)); !errors.Is(err, ErrNoEnvAccount) {
+		t.Errorf("empty environment: %v", err)
+	}
+}
+
+func TestAccountFromEnvErrors(t *testing.T) {
+	secret := "c2VjcmV0!!"
+	cases := []map[string]string{
+		{EnvSeed: secret},
+		{EnvOID: "1", EnvSeed: secret, EnvID: "id", EnvSerial: "1234"},
+		{EnvAccount: `{"seed": "` + secret + `"`},
+		{EnvAccount: "uotp://1234?oid=1&id=id&seed=" + secret},
+		{EnvAccount: "uotp://1234?oid=1&id=id&seed=%zz" + secret},
+		{EnvAccount: "https://1234?seed=" + secret},
+	}
+	for _, env := range cases {
+		_, err := accountFromEnv(envFunc(env))
+		if !errors.Is(err, ErrInvalidAccount) {
+			t.Errorf("%v: got %v", env, err)
+			continue
+		}
+		if strings.Contains(err.Error(), "c2VjcmV0") {
+			t.Errorf("the error shows the secret: %v", err)
+		}
+	}
+}
+
+func TestAccountString(t *testing.T) {
+	a := KeyringAccount{Label: "ci", Account: testAccount}
+	for _, s := range []string{
+		fmt.Sprint(a.Account),
+		fmt.Sprintf("%+v %#v", a.Account, &a.Account),
+		fmt.Sprintf("%v %+v %#v", a, a, a),
+	} {
+		if strings.Contains(s, testAccount.Seed) || strings.Contains(s, testAccount.ID) {
+			t.Errorf("%s shows the secrets", s)
+		}
+		if !strings.Contains(s, testAccount.SerialNumber) {
+			t.Errorf("%s does not show the serial number", s)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/RyuaNerin/uotp"
+)
+
+// Exit codes of every command.
+const (
+	exitOK    = 0
+	exitError = 1
+	exitUsage = 2
+	// exitAlerts is returned by "history report" when an alert fired.
+	exitAlerts = 3
+	// exitNoAccount is returned when the configuration has no account, or
+	// not the selected profile.
+	exitNoAccount = 4
+)
+
+const exitCodesHelp = `Exit codes:
+  0  success
+  1  the command failed
+  2  invalid arguments
+  3  history report: an alert fired
+  4  no account, or the profile was not found`
+
+type command struct {
+	name     string
+	synopsis string
+	help     string
+	run      func(args []string) int
+}
+
+var commands = []command{
+	{"token", "[flags]", "Print the token of the selected profile, or of every profile.", runToken},
+	{"watch", "[flags]", "Show the tokens with a countdown, redrawn in place.", runWatch},
+	{"dashboard", "[flags]", "Open a full-screen view of the accounts and their history.", runDashboard},
+	{"issue", "[flags]", "Issue a new account to a profile.", runIssue},
+	{"sync", "[flags]", "Synchronize the time with the server for every profile.", runSync},
+	{"info", "[flags]", "Print what the server knows about the account.", runInfo},
+	{"reset-errors", "[flags]", "Reset the count of failed authentications of the account.", runResetErrors},
+	{"history", "export|sync|search|report [flags]", "Export, archive, search and report the use history.", runHistory},
+	{"monitor", "[flags]", "Watch the use history and deliver new entries.", runMonitor},
+	{"profiles", "list|add|rm|rename|default [flags]", "Manage the profiles of the configuration file.", runProfiles},
+	{"account", "show [flags]", "Print the account of a profile.", runAccount},
+	{"config", "path|which [flags]", "Print where the configuration file is, and why.", runConfig},
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "usage: uotp <command> [flags]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-13s %s\n", c.name, c.help)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, `Without a command, "token" is run. Run "uotp <command> -h" for the flags of a command.`)
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, exitCodesHelp)
+}
+
+// newFlagSet returns the flags of the command name, whose help starts
+// with "usage: uotp name synopsis".
+func newFlagSet(name, synopsis, help string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		w := fs.Output()
+		fmt.Fprintf(w, "usage: uotp %s %s\n\n%s\n\nFlags:\n", name, synopsis, help)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parseFlags parses args, and returns the exit code when the command must
+// stop: after printing the help, or on invalid flags.
+func parseFlags(fs *flag.FlagSet, args []string) (int, bool) {
+	err := fs.Parse(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return exitOK, false
+	}
+	if err != nil {
+		return exitUsage, false
+	}
+	return exitOK, true
+}
+
+// subcommand returns the subcommand in args, or prints the usage and
+// returns false when it is not one of names.
+func subcommand(command string, args []string, names ...string) (string, bool) {
+	if len(args) > 0 {
+		for _, name := range names {
+			if args[0] == name {
+				return name, true
+			}
+		}
+	}
+	fmt.Fprintf(os.Stderr, "usage: uotp %s %s [flags]\n", command, strings.Join(names, "|"))
+	return "", false
+}
+
+// commonFlags are the flags of the commands using an account.
+type commonFlags struct {
+	confPath    string
+	profile     string
+	readOnly    bool
+	timeSources stringsFlag
+}
+
+func (c *commonFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&c.confPath, "conf", "", confFlagUsage)
+	fs.StringVar(&c.profile, "profile", "", "Profile to use (default: UOTP_PROFILE, or the default profile)")
+	fs.BoolVar(&c.readOnly, "read-only", false, "Never write the configuration file, also set by UOTP_READ_ONLY")
+	fs.Var(&c.timeSources, "time-source", "Time source used when the server does not answer: ntp://host or an http(s) URL, can be repeated")
+}
+
+func (c *commonFlags) path() string {
+	return findConf(c.confPath).Path
+}
+
+func (c *commonFlags) profileName() string {
+	return profileName(c.profile)
+}
+
+func (c *commonFlags) options() ([]uotp.Option, error) {
+	if len(c.timeSources) == 0 {
+		return nil, nil
+	}
+
+	sources := make([]uotp.TimeSource, len(c.timeSources))
+	for i, s := range c.timeSources {
+		var err error
+		sources[i], err = uotp.ParseTimeSource(s)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return []uotp.Option{uotp.WithTimeSources(sources...)}, nil
+}
+
+// config loads the configuration file, or the account given by environment
+// variables unless --conf is given.
+func (c *commonFlags) config() (*config, error) {
+	opts, err := c.options()
+	if err != nil {
+		return nil, err
+	}
+
+	if c.confPath == "" {
+		conf, err := envConfig(opts...)
+		if !errors.Is(err, uotp.ErrNoEnvAccount) {
+			return conf, err
+		}
+	}
+	loc := findConf(c.confPath)
+	return loadConfig(loc.Path, loc.ReadOnly || readOnly(c.readOnly), opts...)
+}
+
+// open loads the configuration file and the account of the selected profile.
+// The configuration stays locked until it is closed.
+func (c *commonFlags) open() (*config, string, uotp.UOTP, error) {
+	conf, err := c.config()
+	if err != nil {
+		return nil, "", nil, err
+	}
+	label, otp, err := conf.profile(c.profileName())
+	if err != nil {
+		conf.close()
+		return nil, "", nil, err
+	}
+	return conf, label, otp, nil
+}
+
+// loadFailed prints why the account could not be loaded and returns the
+// exit code.
+func loadFailed(err error) int {
+	fmt.Fprintln(os.Stderr, "failed to load the account:", err)
+	if errors.Is(err, errNoAccount) || errors.Is(err, uotp.ErrAccountNotFound) {
+		return exitNoAccount
+	}
+	return exitError
+}
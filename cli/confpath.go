@@ -0,0 +1,141 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// confFlagUsage is the usage of --conf.
+const confFlagUsage = `Path to the configuration file (default: the first found, see "uotp config which")`
+
+const (
+	confName = "config.json"
+	// credentialName is the name of the systemd credential holding the
+	// configuration, as in LoadCredential=uotp:/path/to/config.json.
+	credentialName = "uotp"
+	systemConfDir  = "/etc/uotp"
+)
+
+// The places the configuration file is looked for, in order.
+const (
+	sourceFlag        = "--conf"
+	sourceEnv         = "UOTP_CONF"
+	sourceXDG         = "XDG_CONFIG_HOME"
+	sourceLegacy      = "~/.config"
+	sourceCredentials = "CREDENTIALS_DIRECTORY"
+	sourceSystem      = "system"
+)
+
+// The status of a place the configuration file is looked for.
+const (
+	confChosen     = "chosen"
+	confNotSet     = "not set"
+	confNotFound   = "not found"
+	confNotChecked = "not checked"
+)
+
+// confCandidate is a place the configuration file is looked for.
+type confCandidate struct {
+	Source string `json:"source"`
+	Path   string `json:"path,omitempty"`
+	Status string `json:"status"`
+}
+
+// confLocation is the configuration file found by findConf, and why.
+type confLocation struct {
+	Path   string `json:"path"`
+	Source string `json:"source"`
+	Reason string `json:"reason"`
+	// ReadOnly is set for the files given to a service rather than owned by
+	// the user: systemd credentials and the system configuration.
+	ReadOnly   bool            `json:"read_only"`
+	Candidates []confCandidate `json:"candidates"`
+	// EnvAccount is set when the account is given by environment variables
+	// instead, so the file is not used.
+	EnvAccount bool `json:"env_account"`
+}
+
+// findConf finds the configuration file. The path given with --conf or
+// UOTP_CONF is used even if the file does not exist yet. Otherwise the first
+// file found of $XDG_CONFIG_HOME/uotp/config.json, ~/.config/uotp/config.json,
+// $CREDENTIALS_DIRECTORY/uotp and /etc/uotp/config.json is used, or the
+// first one when none exists.
+func findConf(flagPath string) *confLocation {
+	userPath := filepath.Join(xdgConfigHome(), "uotp", confName)
+
+	// Older versions ignored XDG_CONFIG_HOME, so their file is still looked
+	// for when it points elsewhere.
+	legacyPath := filepath.Join(homeConfigDir(), "uotp", confName)
+	if legacyPath == userPath {
+		legacyPath = ""
+	}
+
+	credentialPath := ""
+	if dir := os.Getenv("CREDENTIALS_DIRECTORY"); dir != "" {
+		credentialPath = filepath.Join(dir, credentialName)
+	}
+
+	places := []struct {
+		source    string
+		path      string
+		mustExist bool
+		readOnly  bool
+		reason    string
+	}{
+		{sourceFlag, flagPath, false, false, "it was given with --conf"},
+		{sourceEnv, os.Getenv("UOTP_CONF"), false, false, "it was given with UOTP_CONF"},
+		{sourceXDG, userPath, true, false, "it was found in the configuration directory of the user"},
+		{sourceLegacy, legacyPath, true, false, "it was found in ~/.config, where older versions kept it"},
+		{sourceCredentials, credentialPath, true, true, `it was found as the systemd credential "` + credentialName + `" of the service`},
+		{sourceSystem, filepath.Join(systemConfDir, confName), true, true, "it was found in the system configuration"},
+	}
+
+	loc := &confLocation{}
+	for _, p := range places {
+		c := confCandidate{
+			Source: p.source,
+			Path:   solvePath(p.path),
+		}
+		switch {
+		case p.path == "":
+			c.Status = confNotSet
+		case loc.Path != "":
+			c.Status = confNotChecked
+		case p.mustExist && !fileExists(c.Path):
+			c.Status = confNotFound
+		default:
+			c.Status = confChosen
+			loc.Path = c.Path
+			loc.Source = p.source
+			loc.Reason = p.reason
+			loc.ReadOnly = p.readOnly
+		}
+		loc.Candidates = append(loc.Candidates, c)
+	}
+
+	if loc.Path == "" {
+		loc.Path = userPath
+		loc.Source = sourceXDG
+		loc.Reason = "no configuration file was found, it is created in the configuration directory of the user"
+	}
+	return loc
+}
+
+// homeConfigDir returns ~/.config. Tests replace it.
+var homeConfigDir = func() string {
+	return solvePath("~/.config")
+}
+
+// xdgConfigHome returns $XDG_CONFIG_HOME, or ~/.config when it is not set
+// or not absolute, as the XDG Base Directory Specification says.
+func xdgConfigHome() string {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); filepath.IsAbs(dir) {
+		return dir
+	}
+	return homeConfigDir()
+}
+
+func fileExists(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && !fi.IsDir()
+}
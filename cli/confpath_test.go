@@ -0,0 +1,78 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testConfDirs points the home and XDG_CONFIG_HOME at temporary directories,
+// and clears the other places the configuration is looked for.
+func testConfDirs(t *testing.T) (home, xdg string) {
+	home = filepath.Join(t.TempDir(), ".config")
+	xdg = filepath.Join(t.TempDir(), "xdg")
+
+	saved := homeConfigDir
+	homeConfigDir = func() string { return home }
+	t.Cleanup(func() { homeConfigDir = saved })
+
+	t.Setenv("XDG_CONFIG_HOME", xdg)
+	t.Setenv("UOTP_CONF", "")
+	t.Setenv("CREDENTIALS_DIRECTORY", "")
+	return home, xdg
+}
+
+func writeTestConf(t *testing.T, dir string) string {
+	path := filepath.Join(dir, "uotp", confName)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("{}\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestFindConfLegacy(t *testing.T) {
+	home, xdg := testConfDirs(t)
+
+	legacy := writeTestConf(t, home)
+	loc := findConf("")
+	if loc.Path != legacy || loc.Source != sourceLegacy {
+		t.Fatalf("found %s from %s, want %s from %s", loc.Path, loc.Source, legacy, sourceLegacy)
+	}
+
+	user := writeTestConf(t, xdg)
+	loc = findConf("")
+	if loc.Path != user || loc.Source != sourceXDG {
+		t.Fatalf("found %s from %s, want %s from %s", loc.Path, loc.Source, user, sourceXDG)
+	}
+	if c := loc.Candidates[3]; c.Source != sourceLegacy || c.Status != confNotChecked {
+		t.Fatalf("legacy candidate %+v, want not checked", c)
+	}
+}
+
+func TestFindConfNone(t *testing.T) {
+	_, xdg := testConfDirs(t)
+
+	// A new file goes to XDG_CONFIG_HOME, not the legacy place.
+	loc := findConf("")
+	if want := filepath.Join(xdg, "uotp", confName); loc.Path != want || loc.Source != sourceXDG {
+		t.Fatalf("found %s from %s, want %s from %s", loc.Path, loc.Source, want, sourceXDG)
+	}
+}
+
+func TestFindConfNoXDG(t *testing.T) {
+	home, _ := testConfDirs(t)
+	t.Setenv("XDG_CONFIG_HOME", "")
+
+	// ~/.config is then the XDG place, and is not looked at twice.
+	path := writeTestConf(t, home)
+	loc := findConf("")
+	if loc.Path != path || loc.Source != sourceXDG {
+		t.Fatalf("found %s from %s, want %s from %s", loc.Path, loc.Source, path, sourceXDG)
+	}
+	if c := loc.Candidates[3]; c.Source != sourceLegacy || c.Status != confNotSet {
+		t.Fatalf("legacy candidate %+v, want not set", c)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/RyuaNerin/uotp/internal/dashboard"
+	"github.com/RyuaNerin/uotp/internal/term"
+)
+
+// dashboardRefresh is how often the dashboard is redrawn.
+const dashboardRefresh = 250 * time.Millisecond
+
+func runDashboard(args []string) int {
+	var common commonFlags
+
+	fs := newFlagSet("dashboard", "[flags]", `Open a full-screen view of the accounts with their tokens, and of their
+use history. Press ? for the keys.`)
+	common.register(fs)
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	stdin, stdout := int(os.Stdin.Fd()), int(os.Stdout.Fd())
+	if !term.IsTerminal(stdin) || !term.IsTerminal(stdout) {
+		fmt.Fprintln(os.Stderr, "the dashboard needs a terminal, try uotp watch")
+		return exitError
+	}
+
+	conf, err := common.config()
+	if err != nil {
+		return loadFailed(err)
+	}
+
+	// Saving takes the lock again, so that other runs do not wait meanwhile.
+	conf.close()
+
+	labels := conf.keyring.Labels()
+	if name := common.profileName(); name != "" || len(labels) == 0 {
+		label, _, err := conf.profile(name)
+		if err != nil {
+			return loadFailed(err)
+		}
+		labels = []string{label}
+	}
+	accounts := make([]dashboard.Account, len(labels))
+	for i, label := range labels {
+		otp, _ := conf.keyring.Get(label)
+		accounts[i] = dashboard.Account{Label: label, OTP: otp}
+	}
+
+	m := dashboard.New(accounts, conf.keyring.SyncTime)
+
+	state, err := term.MakeRaw(stdin)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	// Use the alternate screen and hide the cursor, then restore both.
+	fmt.Fprint(os.Stdout, "\x1b[?1049h\x1b[?25l")
+	defer func() {
+		fmt.Fprint(os.Stdout, "\x1b[?25h\x1b[?1049l")
+		term.Restore(stdin, state)
+	}()
+
+	// Signals end the loop, so the terminal is restored by the deferred
+	// function above.
+	ctx, stop := signal.NotifyContext(context.Background(), dashboardSignals...)
+	defer stop()
+
+	msgs := make(chan dashboard.Msg, 16)
+	start := func(cmd dashboard.Cmd) {
+		go func() {
+			msg := cmd(ctx)
+			select {
+			case msgs <- msg:
+			case <-ctx.Done():
+			}
+		}()
+	}
+
+	// The reader stops at the first key after the dashboard returned.
+	go func() {
+		send := func(k dashboard.Key) bool {
+			select {
+			case msgs <- dashboard.KeyMsg(k):
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		buf := make([]byte, 256)
+		for {
+			n, err := os.Stdin.Read(buf)
+			if err != nil {
+				send(dashboard.Key{Special: dashboard.KeyCtrlC})
+				return
+			}
+			for _, k := range dashboard.ParseKeys(buf[:n]) {
+				if !send(k) {
+					return
+				}
+			}
+		}
+	}()
+
+	for _, cmd := range m.Init() {
+		start(cmd)
+	}
+
+	ticker := time.NewTicker(dashboardRefresh)
+	defer ticker.Stop()
+
+	for !m.Quit {
+		if w, h, err := term.Size(stdout); err == nil && (w != m.Width || h != m.Height) {
+			m.Update(dashboard.ResizeMsg{Width: w, Height: h})
+		}
+		draw(m.View())
+
+		var msg dashboard.Msg
+		select {
+		case <-ctx.Done():
+			return exitOK
+		case msg = <-msgs:
+		case now := <-ticker.C:
+			msg = dashboard.TickMsg(now)
+		}
+
+		if cmd := m.Update(msg); cmd != nil {
+			start(cmd)
+		}
+		if r, ok := msg.(dashboard.SyncMsg); ok && r.Err == nil {
+			if err := conf.save(); err != nil && !errors.Is(err, errReadOnly) {
+				m.Status = fmt.Sprintf("failed to save the configuration: %v", err)
+			}
+		}
+	}
+	return exitOK
+}
+
+// draw redraws the screen from its top left corner. The terminal is in raw
+// mode, so lines end with "\r\n".
+func draw(lines []string) {
+	var b strings.Builder
+	b.WriteString("\x1b[H")
+	for i, l := range lines {
+		if i > 0 {
+			b.WriteString("\r\n")
+		}
+		b.WriteString(l)
+		b.WriteString("\x1b[K")
+	}
+	b.WriteString("\x1b[J")
+	os.Stdout.WriteString(b.String())
+}
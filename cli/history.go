@@ -0,0 +1,364 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/RyuaNerin/uotp"
+)
+
+func runHistory(args []string) int {
+	if len(args) > 0 {
+		switch args[0] {
+		case "export":
+			return runHistoryExport(args[1:])
+		case "sync":
+			return runHistorySync(args[1:])
+		case "search":
+			return runHistorySearch(args[1:])
+		case "report":
+			return runHistoryReport(args[1:])
+		}
+	}
+
+	fmt.Fprintln(os.Stderr, "usage: uotp history export|sync|search|report [flags]")
+	return exitUsage
+}
+
+func runHistoryExport(args []string) int {
+	var common commonFlags
+	var as string
+	var period string
+	var outPath string
+	var timeSync syncFlags
+
+	fs := newFlagSet("history export", "[flags]", "Export the use history from the server as csv, jsonl or ics.")
+	common.register(fs)
+	fs.StringVar(&as, "as", "csv", "File format: csv, jsonl or ics")
+	fs.StringVar(&period, "period", "3m", "Period to export: 1w, 1m or 3m")
+	fs.StringVar(&outPath, "out", "-", "Output file, - for stdout")
+	timeSync.register(fs, "Automatically synchronize time before requesting history")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	historyPeriod, err := uotp.ParseHistoryPeriod(period)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+	if _, err = uotp.NewHistoryEncoder(io.Discard, uotp.HistoryFormat(as)); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+
+	conf, _, otp, err := common.open()
+	if err != nil {
+		return loadFailed(err)
+	}
+	defer conf.close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if err = timeSync.sync(ctx, conf); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+
+	var w io.Writer = os.Stdout
+	if outPath != "-" {
+		f, err := os.OpenFile(outPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitError
+		}
+		defer f.Close()
+		w = f
+	}
+	bw := bufio.NewWriter(w)
+
+	enc, err := uotp.NewHistoryEncoder(bw, uotp.HistoryFormat(as))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+
+	it := otp.HistoryAll(ctx, historyPeriod)
+	for it.Next() {
+		err = enc.Encode(it.Entry())
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitError
+		}
+	}
+	if err = it.Err(); err != nil {
+		fmt.Fprintln(os.Stderr, "failed to get history:", err)
+		return exitError
+	}
+
+	if err = enc.Close(); err == nil {
+		err = bw.Flush()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+
+	return exitOK
+}
+
+// archivePath returns where the history archive of the account is kept,
+// next to the configuration file.
+func archivePath(confPath string, otp uotp.UOTP) string {
+	name := strings.ReplaceAll(otp.GetSerialNumber(), "-", "")
+	return filepath.Join(filepath.Dir(confPath), "history", name+".hist")
+}
+
+// openArchive loads the configuration, the account and its history archive.
+// archiveFile overrides archivePath when it is not empty.
+func openArchive(common *commonFlags, archiveFile string) (*config, uotp.UOTP, *uotp.HistoryArchive, error) {
+	conf, _, otp, err := common.open()
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to load the account: %w", err)
+	}
+
+	if archiveFile == "" {
+		archiveFile = archivePath(common.path(), otp)
+	}
+	archive, err := uotp.OpenHistoryArchive(solvePath(archiveFile))
+	if err != nil {
+		conf.close()
+		return nil, nil, nil, err
+	}
+
+	return conf, otp, archive, nil
+}
+
+// syncArchive brings the archive up to date with the server.
+func syncArchive(ctx context.Context, conf *config, otp uotp.UOTP, archive *uotp.HistoryArchive, period uotp.HistoryPeriod, timeSync *syncFlags) (int, error) {
+	if err := timeSync.sync(ctx, conf); err != nil {
+		return 0, err
+	}
+
+	n, err := archive.Sync(ctx, otp, period)
+	if err != nil {
+		return 0, fmt.Errorf("failed to sync history: %w", err)
+	}
+	return n, nil
+}
+
+func runHistorySync(args []string) int {
+	var common commonFlags
+	var out outputFlags
+	var archiveFile string
+	var period string
+	var timeSync syncFlags
+
+	fs := newFlagSet("history sync", "[flags]", `Copy the use history from the server to the local archive.
+
+The json output and the template get the fields Added, Archived and Archive.
+raw prints the number of new entries.`)
+	common.register(fs)
+	out.register(fs)
+	fs.StringVar(&archiveFile, "archive", "", "Path to the history archive (default: next to the configuration file)")
+	fs.StringVar(&period, "period", "3m", "Period to fetch: 1w, 1m or 3m")
+	timeSync.register(fs, "Automatically synchronize time before requesting history")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	historyPeriod, err := uotp.ParseHistoryPeriod(period)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+	if err = out.parse(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+
+	conf, otp, archive, err := openArchive(&common, archiveFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	defer conf.close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	n, err := syncArchive(ctx, conf, otp, archive, historyPeriod, &timeSync)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+
+	result := historySyncResult{
+		Added:    n,
+		Archived: archive.Len(),
+		Archive:  archive.Path(),
+	}
+	err = out.write(result, func(w io.Writer) {
+		fmt.Fprintf(w, "%d new entries, %d archived in %s\n", result.Added, result.Archived, result.Archive)
+	}, func(w io.Writer) {
+		fmt.Fprintln(w, result.Added)
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	return exitOK
+}
+
+func runHistorySearch(args []string) int {
+	var common commonFlags
+	var archiveFile string
+	var from, to string
+	var filter uotp.HistoryFilter
+	var out outputFlags
+	var as string
+	var doSync bool
+	var timeSync syncFlags
+
+	fs := newFlagSet("history search", "[flags]", `Search the local history archive.
+
+The json output is the list of entries, the template is applied to each
+entry with the fields At, Type and Name. --as writes the entries as a file
+instead, and cannot be used with --output.`)
+	common.register(fs)
+	out.register(fs)
+	fs.StringVar(&archiveFile, "archive", "", "Path to the history archive (default: next to the configuration file)")
+	fs.StringVar(&from, "from", "", "Only entries at or after this date (2006-01-02 or RFC 3339)")
+	fs.StringVar(&to, "to", "", "Only entries at or before this date (2006-01-02 or RFC 3339)")
+	fs.StringVar(&filter.Type, "type", "", "Only entries whose type contains this text")
+	fs.StringVar(&filter.Name, "name", "", "Only entries whose service name contains this text")
+	fs.StringVar(&as, "as", "", "Write the entries as csv, jsonl or ics")
+	fs.BoolVar(&doSync, "sync", false, "Sync the archive with the server first")
+	timeSync.register(fs, "Automatically synchronize time before requesting history")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	var err error
+	if filter.From, err = parseSearchTime(from, false); err != nil {
+		fmt.Fprintln(os.Stderr, "invalid --from:", err)
+		return exitUsage
+	}
+	if filter.To, err = parseSearchTime(to, true); err != nil {
+		fmt.Fprintln(os.Stderr, "invalid --to:", err)
+		return exitUsage
+	}
+	if err = out.parse(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+	if as != "" {
+		if out.machine() {
+			fmt.Fprintf(os.Stderr, "--as cannot be used with --output %s\n", out.output)
+			return exitUsage
+		}
+		if _, err = uotp.NewHistoryEncoder(io.Discard, uotp.HistoryFormat(as)); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitUsage
+		}
+	}
+
+	conf, otp, archive, err := openArchive(&common, archiveFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	defer conf.close()
+
+	if doSync {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+
+		_, err = syncArchive(ctx, conf, otp, archive, uotp.HistoryPeriodThreeMonths, &timeSync)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitError
+		}
+	}
+
+	entries := archive.Search(filter)
+	if as == "" {
+		if entries == nil {
+			entries = []uotp.HistoryEntry{}
+		}
+		err = out.write(entries, func(w io.Writer) {
+			bw := bufio.NewWriter(w)
+			enc := &historyTextEncoder{w: bw}
+			for _, entry := range entries {
+				enc.Encode(entry)
+			}
+			bw.Flush()
+		}, nil)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitError
+		}
+		return exitOK
+	}
+
+	bw := bufio.NewWriter(os.Stdout)
+	enc, _ := uotp.NewHistoryEncoder(bw, uotp.HistoryFormat(as))
+	for _, entry := range entries {
+		if err = enc.Encode(entry); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = enc.Close()
+	}
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+
+	return exitOK
+}
+
+// parseSearchTime parses a date or an RFC 3339 time. A date given as the end
+// of a range includes the whole day.
+func parseSearchTime(s string, end bool) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", s, time.Local)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if end {
+		t = t.AddDate(0, 0, 1).Add(-time.Nanosecond)
+	}
+	return t, nil
+}
+
+// historyTextEncoder writes one entry per line for reading in a terminal.
+type historyTextEncoder struct {
+	w io.Writer
+}
+
+func (e *historyTextEncoder) Encode(entry uotp.HistoryEntry) error {
+	_, err := fmt.Fprintf(e.w, "%s  %s  %s\n", entry.At.Format("2006-01-02 15:04:05"), entry.Type, entry.Name)
+	return err
+}
+
+func (e *historyTextEncoder) Close() error {
+	return nil
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/RyuaNerin/uotp"
+	"github.com/RyuaNerin/uotp/internal/fileutil"
+)
+
+type historyReport struct {
+	Stats  *uotp.HistoryStats `json:"stats"`
+	Alerts []uotp.Alert       `json:"alerts"`
+}
+
+func runHistoryReport(args []string) int {
+	var common commonFlags
+	var archiveFile string
+	var baselineFile string
+	var days int
+	var learn bool
+	var quietHours string
+	var out outputFlags
+	var doSync bool
+	var timeSync syncFlags
+
+	rules := uotp.DefaultHistoryRules()
+
+	fs := newFlagSet("history report", "[flags]", `Report statistics of the history archive and alert on unusual use.
+
+The json output and the template get the fields Stats and Alerts. raw prints
+the number of alerts.`)
+	common.register(fs)
+	out.register(fs)
+	fs.StringVar(&archiveFile, "archive", "", "Path to the history archive (default: next to the configuration file)")
+	fs.StringVar(&baselineFile, "baseline", "", "Path to the baseline (default: next to the archive)")
+	fs.IntVar(&days, "days", 7, "Report on the entries of the last days")
+	fs.BoolVar(&learn, "learn", false, "Learn the baseline from the archived entries before the report")
+	fs.StringVar(&quietHours, "quiet-hours", fmt.Sprintf("%d-%d", rules.QuietHoursStart, rules.QuietHoursEnd), "Hours in which any activity is an alert, as start-end, or none")
+	fs.BoolVar(&rules.UnknownService, "unknown-service", rules.UnknownService, "Alert on services missing from the baseline")
+	fs.IntVar(&rules.BurstCount, "burst-count", rules.BurstCount, "Authentications within --burst-window that make a burst, 0 to disable")
+	fs.DurationVar(&rules.BurstWindow, "burst-window", rules.BurstWindow, "Window for --burst-count")
+	fs.IntVar(&rules.MaxFailures, "max-failures", rules.MaxFailures, "Failures within --failure-window that raise an alert, 0 to disable")
+	fs.DurationVar(&rules.FailureWindow, "failure-window", rules.FailureWindow, "Window for --max-failures")
+	fs.BoolVar(&doSync, "sync", false, "Sync the archive with the server first")
+	timeSync.register(fs, "Automatically synchronize time before requesting history")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	var err error
+	rules.QuietHoursStart, rules.QuietHoursEnd, err = parseHourRange(quietHours)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "invalid --quiet-hours:", err)
+		return exitUsage
+	}
+	if days < 1 {
+		fmt.Fprintln(os.Stderr, "--days must be 1 or greater")
+		return exitUsage
+	}
+	if err = out.parse(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+
+	conf, otp, archive, err := openArchive(&common, archiveFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	defer conf.close()
+
+	if doSync {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+
+		_, err = syncArchive(ctx, conf, otp, archive, uotp.HistoryPeriodThreeMonths, &timeSync)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitError
+		}
+	}
+
+	if baselineFile == "" {
+		baselineFile = strings.TrimSuffix(archive.Path(), filepath.Ext(archive.Path())) + ".baseline.json"
+	}
+	baselineFile = solvePath(baselineFile)
+
+	since := time.Now().AddDate(0, 0, -days)
+	recent := archive.Search(uotp.HistoryFilter{From: since})
+	past := archive.Search(uotp.HistoryFilter{To: since.Add(-time.Nanosecond)})
+
+	var baseline *uotp.HistoryBaseline
+	if learn {
+		baseline = uotp.LearnHistoryBaseline(past)
+		if err = saveBaseline(baselineFile, baseline); err != nil {
+			fmt.Fprintln(os.Stderr, "failed to save the baseline:", err)
+			return exitError
+		}
+	} else {
+		baseline, err = loadBaseline(baselineFile)
+		if os.IsNotExist(err) {
+			baseline, err = uotp.LearnHistoryBaseline(past), nil
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "failed to load the baseline:", err)
+			return exitError
+		}
+	}
+	if baseline.Total == 0 {
+		// Every service would be unknown without any history to compare to.
+		baseline = nil
+	}
+
+	report := historyReport{
+		Stats:  uotp.ComputeHistoryStats(recent),
+		Alerts: uotp.DetectAnomalies(recent, baseline, rules),
+	}
+
+	err = out.write(&report, func(w io.Writer) {
+		printReport(w, &report, since)
+	}, func(w io.Writer) {
+		fmt.Fprintln(w, len(report.Alerts))
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+
+	if len(report.Alerts) > 0 {
+		return exitAlerts
+	}
+	return exitOK
+}
+
+// parseHourRange parses "start-end" or "none".
+func parseHourRange(s string) (start, end int, err error) {
+	if s == "none" || s == "" {
+		return 0, 0, nil
+	}
+	_, err = fmt.Sscanf(s, "%d-%d", &start, &end)
+	if err != nil {
+		return 0, 0, err
+	}
+	if start < 0 || 23 < start || end < 0 || 24 < end {
+		return 0, 0, fmt.Errorf("hours must be between 0 and 24")
+	}
+	return start, end, nil
+}
+
+func loadBaseline(path string) (*uotp.HistoryBaseline, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var baseline uotp.HistoryBaseline
+	err = json.Unmarshal(data, &baseline)
+	if err != nil {
+		return nil, err
+	}
+	return &baseline, nil
+}
+
+func saveBaseline(path string, baseline *uotp.HistoryBaseline) error {
+	data, err := json.MarshalIndent(baseline, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return fileutil.WriteFile(path, append(data, '\n'), 0600)
+}
+
+func printReport(w io.Writer, report *historyReport, since time.Time) error {
+	var sb strings.Builder
+
+	stats := report.Stats
+	fmt.Fprintf(&sb, "%d entries since %s\n", stats.Total, since.Format("2006-01-02 15:04"))
+
+	if len(stats.Services) > 0 {
+		sb.WriteString("\nServices:\n")
+		for _, svc := range stats.Services {
+			fmt.Fprintf(&sb, "  %5d  %s  (last %s)\n", svc.Count, svc.Name, svc.Last.Format("2006-01-02 15:04"))
+		}
+
+		max := 0
+		for _, n := range stats.ByHour {
+			if n > max {
+				max = n
+			}
+		}
+		sb.WriteString("\nBy hour:\n")
+		for hour, n := range stats.ByHour {
+			fmt.Fprintf(&sb, "  %02d  %5d  %s\n", hour, n, strings.Repeat("#", (n*40+max-1)/max))
+		}
+	}
+
+	if len(report.Alerts) > 0 {
+		fmt.Fprintf(&sb, "\n%d alerts:\n", len(report.Alerts))
+		for _, alert := range report.Alerts {
+			fmt.Fprintf(&sb, "  %s  %-15s  %s\n", alert.At.Format("2006-01-02 15:04:05"), alert.Rule, alert.Message)
+		}
+	} else {
+		sb.WriteString("\nNo alerts.\n")
+	}
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
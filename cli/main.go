@@ -1,119 +1,653 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"os"
+	"os/signal"
 	"os/user"
 	"path/filepath"
 	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/RyuaNerin/uotp"
 )
 
 func main() {
-	var flagIssue bool
-	var flagForce bool
-	var confPath string
-	var autoSync bool
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	// Without a command, as in older versions, the token is printed.
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") && args[0] != "-h" && args[0] != "-help" && args[0] != "--help" {
+		return runToken(args)
+	}
 
-	flag.BoolVar(&flagIssue, "issue", false, "Issue a new account")
-	flag.BoolVar(&flagForce, "force", false, "Never prompt")
-	flag.StringVar(&confPath, "conf", "~/.config/uotp/config.json", "Path to the configuration file.")
-	flag.BoolVar(&autoSync, "autosync", true, "Automatically synchronize time before generating OTP tokens")
-	flag.Parse()
+	switch args[0] {
+	case "help", "-h", "-help", "--help":
+		if len(args) > 1 && args[0] == "help" {
+			return run([]string{args[1], "-h"})
+		}
+		printUsage(os.Stdout)
+		return exitOK
+	}
 
-	if confPathEnv := os.Getenv("UOTP_CONF"); confPathEnv != "" {
-		confPath = confPathEnv
+	for _, c := range commands {
+		if c.name == args[0] {
+			return c.run(args[1:])
+		}
 	}
-	confPath = solvePath(confPath)
 
-	_, err := os.Stat(confPath)
-	exists := !os.IsNotExist(err)
+	fmt.Fprintf(os.Stderr, "uotp: unknown command %q\n\n", args[0])
+	printUsage(os.Stderr)
+	return exitUsage
+}
+
+func runToken(args []string) int {
+	var common commonFlags
+	var out outputFlags
+	var timeSync syncFlags
 
-	var otp uotp.UOTP
+	fs := newFlagSet("token", "[flags]", `Print the token of the selected profile. Without a profile, the token of
+every profile is printed with its label.
 
-	if flagIssue || !exists {
-		if exists {
-			confirm(flagForce, "Account already exists. Do you want to replace it?")
-		} else {
-			confirm(flagForce, "Account not exists. Do you want to issue one now?")
+The json output and the template get the fields Profile, SerialNumber,
+Token, Digits, Remaining (seconds), Expires, TimeOffset (seconds),
+TimeSource, TimeFallback and TimeSyncedAt, e.g.
+--format '{{.Token}} {{printf "%.0f" .Remaining}}s'.
+
+The time is synchronized when the saved offset is older than --sync-ttl.
+With --offline, the saved offset is used however old it is.`)
+	common.register(fs)
+	out.register(fs)
+	timeSync.register(fs, "Synchronize time before generating tokens")
+	timeSync.registerOffline(fs)
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if err := out.parse(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+
+	conf, err := common.config()
+	if err != nil {
+		return loadFailed(err)
+	}
+	defer conf.close()
+	name := common.profileName()
+
+	// Without a profile, every profile is printed when there are several.
+	labels := conf.keyring.Labels()
+	single := name != "" || len(labels) <= 1
+	if single {
+		label, _, err := conf.profile(name)
+		if err != nil {
+			return loadFailed(err)
 		}
+		labels = []string{label}
+	}
 
-		otp, _ = uotp.New(nil)
-		if autoSync {
-			err = otp.SyncTime(context.Background())
-			if err != nil {
-				panic(err)
-			}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if err = timeSync.sync(ctx, conf); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+
+	results := make([]tokenResult, len(labels))
+	for i, label := range labels {
+		otp, _ := conf.keyring.Get(label)
+		results[i] = newTokenResult(label, otp)
+	}
+
+	var result interface{} = results
+	if single {
+		result = results[0]
+	}
+	err = out.write(result, func(w io.Writer) {
+		if single {
+			fmt.Fprintln(w, "Serial Number:", results[0].SerialNumber)
+			fmt.Fprintln(w, "OTP Token:", results[0].Token)
+			return
 		}
-		err = otp.Issue(context.Background())
-		if err != nil {
-			panic(err)
+		for _, r := range results {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", r.Profile, r.SerialNumber, r.Token)
+		}
+	}, func(w io.Writer) {
+		for _, r := range results {
+			fmt.Fprintln(w, r.Digits)
+		}
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+
+	// The profiles share the time synchronization, so one warning is enough.
+	for _, label := range labels {
+		otp, _ := conf.keyring.Get(label)
+		if account := otp.GetAccount(); account.TimeFallback {
+			warnTimeSource(account)
+			break
 		}
+	}
+	return exitOK
+}
 
-		save(confPath, otp.GetAccount())
+func runIssue(args []string) int {
+	var common commonFlags
+	var out outputFlags
+	var force bool
+	var setDefault bool
 
-		fmt.Println("A new account has been issued.")
-		fmt.Println("Please keep your configuration file safe as it is not possible to recover the account if it gets lost.")
-		fmt.Println()
-		fmt.Println("Serial Number:", otp.GetSerialNumber())
-	} else {
-		fs, err := os.Open(confPath)
-		if err != nil {
-			panic(err)
+	fs := newFlagSet("issue", "[flags]", `Issue a new account to the profile given by --profile, "default" if there
+is none. Replacing an existing account asks for confirmation.`)
+	common.register(fs)
+	out.register(fs)
+	fs.BoolVar(&force, "force", false, "Replace an existing account without asking")
+	fs.BoolVar(&setDefault, "default", false, "Make the profile the default")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if err := out.parse(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+
+	conf, err := common.config()
+	if err != nil {
+		return loadFailed(err)
+	}
+	defer conf.close()
+
+	// The account would be lost.
+	if conf.fromEnv {
+		fmt.Fprintf(os.Stderr, "cannot issue an account while the account is given by %s or %s\n", uotp.EnvAccount, uotp.EnvSeed)
+		return exitError
+	}
+	if conf.readOnly {
+		fmt.Fprintln(os.Stderr, "cannot issue an account:", errReadOnly)
+		return exitError
+	}
+
+	label := common.profileName()
+	if label == "" {
+		label = defaultProfile
+	}
+	if label, ok := conf.keyring.Label(label); ok {
+		if !confirm(force, fmt.Sprintf("Profile %s already has an account. Do you want to replace it?", label)) {
+			fmt.Fprintln(os.Stderr, "aborted")
+			return exitError
 		}
-		defer fs.Close()
+		conf.keyring.Remove(label)
+	}
 
-		var account uotp.Account
-		err = json.NewDecoder(fs).Decode(&account)
-		if err != nil && err != io.EOF {
-			panic(err)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	// The token sent with the request must match the server's time.
+	if _, err = conf.keyring.SyncTime(ctx); err != nil {
+		fmt.Fprintln(os.Stderr, "failed to synchronize time:", err)
+		return exitError
+	}
+	otp, err := conf.keyring.Issue(ctx, label)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to issue an account:", err)
+		return exitError
+	}
+	if setDefault || conf.Default == "" {
+		conf.Default = label
+	}
+
+	if err = conf.save(); err != nil {
+		fmt.Fprintln(os.Stderr, "failed to save the configuration:", err)
+		return exitError
+	}
+
+	result := profileResult{
+		Profile:      label,
+		SerialNumber: otp.GetSerialNumber(),
+		Default:      conf.Default == label,
+	}
+	err = out.write(result, func(w io.Writer) {
+		fmt.Fprintln(w, "A new account has been issued.")
+		fmt.Fprintln(w, "Please keep your configuration file safe as it is not possible to recover the account if it gets lost.")
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "Profile:", result.Profile)
+		fmt.Fprintln(w, "Serial Number:", result.SerialNumber)
+	}, func(w io.Writer) {
+		fmt.Fprintln(w, result.SerialNumber)
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	return exitOK
+}
+
+func runSync(args []string) int {
+	var common commonFlags
+	var out outputFlags
+
+	fs := newFlagSet("sync", "[flags]", `Synchronize the time with the server, or the fallback time sources, and
+save the offset for every profile.
+
+The json output and the template get the fields Offset, Uncertainty and
+RTT in seconds, Confidence, Samples, Rejected, Source and Authoritative.`)
+	common.register(fs)
+	out.register(fs)
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if err := out.parse(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+
+	conf, err := common.config()
+	if err != nil {
+		return loadFailed(err)
+	}
+	defer conf.close()
+	if conf.keyring.Len() == 0 {
+		return loadFailed(fmt.Errorf("%s: %w", conf.path, errNoAccount))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	r, err := conf.keyring.SyncTime(ctx)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to synchronize time:", err)
+		return exitError
+	}
+	if err = conf.save(); errors.Is(err, errReadOnly) {
+		// An account given by the environment is never saved anyway.
+		if !conf.fromEnv {
+			fmt.Fprintln(os.Stderr, "warning: the offset is not saved, the configuration is read-only")
 		}
+	} else if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to save the configuration:", err)
+		return exitError
+	}
 
-		otp, err = uotp.New(&account)
-		if err != nil {
-			panic(err)
+	result := syncResult{
+		Offset:        r.Offset.Seconds(),
+		Uncertainty:   r.Uncertainty.Seconds(),
+		RTT:           r.RTT.Seconds(),
+		Confidence:    r.Confidence,
+		Samples:       r.Samples,
+		Rejected:      r.Rejected,
+		Source:        r.Source,
+		Authoritative: r.Authoritative,
+	}
+	err = out.write(result, func(w io.Writer) {
+		fmt.Fprintf(w, "Offset: %s ± %s\n", r.Offset.Round(time.Millisecond), r.Uncertainty.Round(time.Millisecond))
+		fmt.Fprintf(w, "Source: %s\n", r.Source)
+		fmt.Fprintf(w, "Confidence: %.0f%%\n", r.Confidence*100)
+	}, func(w io.Writer) {
+		fmt.Fprintf(w, "%.3f\n", result.Offset)
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	if !r.Authoritative {
+		fmt.Fprintf(os.Stderr, "warning: the time was synchronized with %s, not the μOTP+ server\n", r.Source)
+	}
+	return exitOK
+}
+
+func runInfo(args []string) int {
+	var common commonFlags
+	var out outputFlags
+	var timeSync syncFlags
+
+	fs := newFlagSet("info", "[flags]", `Print the account of the profile and what the server knows about it.
+
+The json output and the template get the fields Profile, SerialNumber, OID,
+Partner, TimeOffset and TimeUncertainty in seconds, TimeSource and Device.`)
+	common.register(fs)
+	out.register(fs)
+	timeSync.register(fs, "Synchronize time before asking the server")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if err := out.parse(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+
+	conf, label, otp, err := common.open()
+	if err != nil {
+		return loadFailed(err)
+	}
+	defer conf.close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if err = timeSync.sync(ctx, conf); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	info, err := otp.GetInformation(ctx)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to get information:", err)
+		return exitError
+	}
+
+	account := otp.GetAccount()
+	result := infoResult{
+		Profile:         label,
+		SerialNumber:    account.SerialNumber,
+		OID:             info.OID,
+		Partner:         info.Partner,
+		TimeOffset:      timeOffset(account).Seconds(),
+		TimeUncertainty: account.TimeUncertainty.Seconds(),
+		TimeSource:      orDefault(account.TimeSource, uotp.ServerTimeSource),
+		Device:          account.Device,
+	}
+	err = out.write(result, func(w io.Writer) {
+		fmt.Fprintln(w, "Profile:", label)
+		fmt.Fprintln(w, "Serial Number:", account.SerialNumber)
+		fmt.Fprintln(w, "OID:", info.OID)
+		fmt.Fprintln(w, "Partner:", info.Partner)
+		fmt.Fprintf(w, "Time Offset: %s ± %s (%s)\n", timeOffset(account).Round(time.Millisecond),
+			account.TimeUncertainty.Round(time.Millisecond), result.TimeSource)
+		if account.Device != nil {
+			fmt.Fprintf(w, "Device: %s %s, app %s\n", account.Device.Carrier, account.Device.Model, account.Device.AppVersion)
 		}
+	}, nil)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	return exitOK
+}
+
+func runResetErrors(args []string) int {
+	var common commonFlags
+	var out outputFlags
+	var timeSync syncFlags
+
+	fs := newFlagSet("reset-errors", "[flags]", `Reset the count of failed authentications of the account on the server.
 
-		fs.Close()
+The json output and the template get the fields Profile and SerialNumber.`)
+	common.register(fs)
+	out.register(fs)
+	timeSync.register(fs, "Synchronize time before asking the server")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if err := out.parse(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
 
-		fmt.Println("Serial Number:", otp.GetSerialNumber())
+	conf, label, otp, err := common.open()
+	if err != nil {
+		return loadFailed(err)
 	}
+	defer conf.close()
 
-	if autoSync {
-		err = otp.SyncTime(context.Background())
-		if err != nil {
-			panic(err)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if err = timeSync.sync(ctx, conf); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	if err = otp.ResetErrorCount(ctx); err != nil {
+		fmt.Fprintln(os.Stderr, "failed to reset the error count:", err)
+		return exitError
+	}
+
+	result := resetResult{
+		Profile:      label,
+		SerialNumber: otp.GetSerialNumber(),
+	}
+	err = out.write(result, func(w io.Writer) {
+		fmt.Fprintln(w, "The error count has been reset.")
+	}, func(w io.Writer) {
+		fmt.Fprintln(w, result.SerialNumber)
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	return exitOK
+}
+
+func runAccount(args []string) int {
+	if _, ok := subcommand("account", args, "show"); !ok {
+		return exitUsage
+	}
+
+	var common commonFlags
+	var out outputFlags
+	var secrets bool
+
+	fs := newFlagSet("account show", "[flags]", `Print the account of the profile as JSON. The ID and the seed are hidden
+unless --secrets is given, and always when the account is given by
+environment variables.
+
+The template gets the fields Label and Account, which has the fields of the
+JSON. raw prints the serial number.`)
+	common.register(fs)
+	out.register(fs)
+	fs.BoolVar(&secrets, "secrets", false, "Print the ID and the seed")
+	if code, ok := parseFlags(fs, args[1:]); !ok {
+		return code
+	}
+	if err := out.parse(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+
+	conf, label, otp, err := common.open()
+	if err != nil {
+		return loadFailed(err)
+	}
+	conf.close()
+
+	// They were given as masked variables, and would end up in logs.
+	if secrets && conf.fromEnv {
+		fmt.Fprintln(os.Stderr, "the secrets of an account given by the environment are not printed")
+		return exitError
+	}
+
+	account := otp.GetAccount()
+	if !secrets {
+		account = redactAccount(account)
+	}
+
+	result := uotp.KeyringAccount{
+		Label:   label,
+		Account: account,
+	}
+	// The text output is the JSON as well, it is what the command is for.
+	err = out.write(result, func(w io.Writer) {
+		e := json.NewEncoder(w)
+		e.SetIndent("", "  ")
+		e.Encode(result)
+	}, func(w io.Writer) {
+		fmt.Fprintln(w, account.SerialNumber)
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	return exitOK
+}
+
+// redactAccount hides the secrets of account.
+func redactAccount(account uotp.Account) uotp.Account {
+	const hidden = "(hidden)"
+	if account.ID != "" {
+		account.ID = hidden
+	}
+	if account.Seed != "" {
+		account.Seed = hidden
+	}
+	return account
+}
+
+func runConfig(args []string) int {
+	cmd, ok := subcommand("config", args, "path", "which")
+	if !ok {
+		return exitUsage
+	}
+
+	var confPath string
+	var out outputFlags
+
+	var fs *flag.FlagSet
+	if cmd == "path" {
+		fs = newFlagSet("config path", "[flags]", `Print the path of the configuration file.
+
+The json output is the one of config which.`)
+	} else {
+		fs = newFlagSet("config which", "[flags]", `Print which configuration file is used and why, with the places looked
+at in order:
+
+  --conf                 the flag
+  UOTP_CONF              the environment variable
+  XDG_CONFIG_HOME        $XDG_CONFIG_HOME/uotp/config.json, ~/.config if unset
+  ~/.config              ~/.config/uotp/config.json, when XDG_CONFIG_HOME is elsewhere
+  CREDENTIALS_DIRECTORY  $CREDENTIALS_DIRECTORY/uotp, a systemd credential
+  system                 /etc/uotp/config.json
+
+The first two are used even if the file does not exist yet. Otherwise the
+first file found is used, or the one in XDG_CONFIG_HOME when there is none.
+Systemd credentials and the system configuration are read-only.
+
+Without --conf, an account given by UOTP_ACCOUNT, or by UOTP_OID, UOTP_SEED,
+UOTP_ID and UOTP_SERIAL, is used instead of the file, and never saved.
+
+Paths may use $VAR and ~. The json output has the fields Path, Source,
+Reason, ReadOnly, Candidates and EnvAccount.`)
+	}
+	out.register(fs)
+	fs.StringVar(&confPath, "conf", "", confFlagUsage)
+	if code, ok := parseFlags(fs, args[1:]); !ok {
+		return code
+	}
+
+	if err := out.parse(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+
+	loc := findConf(confPath)
+	loc.ReadOnly = loc.ReadOnly || readOnly(false)
+
+	var envErr error
+	if confPath == "" {
+		_, envErr = uotp.AccountFromEnv()
+		loc.EnvAccount = !errors.Is(envErr, uotp.ErrNoEnvAccount)
+	}
+
+	printPath := func(w io.Writer) {
+		fmt.Fprintln(w, loc.Path)
+	}
+	if cmd == "path" {
+		if err := out.write(loc, printPath, printPath); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitError
 		}
+		return exitOK
+	}
 
-		save(confPath, otp.GetAccount())
+	err := out.write(loc, func(w io.Writer) {
+		fmt.Fprintln(w, loc.Path)
+		mode := ""
+		if loc.ReadOnly {
+			mode = ", and is read-only"
+		}
+		fmt.Fprintf(w, "It is used because %s%s.\n", loc.Reason, mode)
+		if loc.EnvAccount {
+			fmt.Fprintln(w, "But the account is given by environment variables, so the file is not used.")
+			if envErr != nil {
+				fmt.Fprintln(w, "They are invalid:", envErr)
+			}
+		}
+		fmt.Fprintln(w)
+
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		for _, c := range loc.Candidates {
+			fmt.Fprintf(tw, "  %s\t%s\t%s\n", c.Source, orDefault(c.Path, "-"), c.Status)
+		}
+		tw.Flush()
+	}, printPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
 	}
+	return exitOK
+}
 
-	fmt.Println("OTP Token:", otp.GenerateToken())
+// timeOffset returns the offset of account with its full precision.
+func timeOffset(account uotp.Account) time.Duration {
+	if account.TimeOffset != 0 {
+		return account.TimeOffset
+	}
+	return time.Duration(account.TimeDiff) * time.Second
 }
 
-func confirm(force bool, body string) {
-	if force {
-		return
+func orDefault(s, def string) string {
+	if s == "" {
+		return def
 	}
+	return s
+}
 
-	fmt.Println(body, " Y/N")
+// warnTimeSource warns when the clock offset was not measured with the server.
+func warnTimeSource(account uotp.Account) {
+	if account.TimeFallback {
+		fmt.Fprintf(os.Stderr, "warning: the time was synchronized with %s, not the μOTP+ server (± %s)\n",
+			account.TimeSource, account.TimeUncertainty.Round(time.Millisecond))
+	}
+}
 
-	b := make([]byte, 1)
-	os.Stdin.Read(b)
-	if b[0] != 'Y' {
-		os.Exit(1)
+// confirm asks the question body on stderr, and reports whether it was
+// answered with yes.
+func confirm(force bool, body string) bool {
+	if force {
+		return true
 	}
+
+	fmt.Fprint(os.Stderr, body, " [y/N] ")
+
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
 }
 
+// solvePath replaces $VAR and ${VAR} with the environment, then a leading
+// "~" with the home directory.
 func solvePath(path string) string {
+	path = os.ExpandEnv(path)
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+
 	// https://stackoverflow.com/a/17617721
-	usr, _ := user.Current()
-	dir := usr.HomeDir
+	var dir string
+	if usr, err := user.Current(); err == nil {
+		dir = usr.HomeDir
+	} else {
+		// Containers may run as a user without a passwd entry.
+		dir, _ = os.UserHomeDir()
+	}
 
 	if path == "~" {
 		// In case of "~", which won't be caught by the "else if"
@@ -126,23 +660,3 @@ func solvePath(path string) string {
 
 	return path
 }
-
-func save(path string, account uotp.Account) {
-	err := os.MkdirAll(filepath.Dir(path), 0700)
-	if err != nil {
-		panic(err)
-	}
-
-	fs, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
-	if err != nil {
-		panic(err)
-	}
-	defer fs.Close()
-
-	e := json.NewEncoder(fs)
-	e.SetIndent("", "  ")
-	err = e.Encode(&account)
-	if err != nil {
-		panic(err)
-	}
-}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/RyuaNerin/uotp"
+)
+
+// stringsFlag collects every value of a repeated flag.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ", ")
+}
+
+func (f *stringsFlag) Set(s string) error {
+	*f = append(*f, s)
+	return nil
+}
+
+// shellCommand returns how to run command through the shell.
+func shellCommand(command string) (string, []string) {
+	if runtime.GOOS == "windows" {
+		return "cmd", []string{"/C", command}
+	}
+	return "/bin/sh", []string{"-c", command}
+}
+
+// outputSink prints each new entry to stdout as chosen by out.
+func outputSink(out *outputFlags) uotp.HistorySink {
+	return uotp.HistorySinkFunc(func(ctx context.Context, event *uotp.HistoryEvent) error {
+		return out.write(event, func(w io.Writer) {
+			fmt.Fprintf(w, "%s  %s  %s  %s\n", event.At.Format("2006-01-02 15:04:05"), event.Kind, event.Type, event.Name)
+		}, nil)
+	})
+}
+
+func runMonitor(args []string) int {
+	var common commonFlags
+	var out outputFlags
+	var cursorFile string
+	var period string
+	var webhooks stringsFlag
+	var commands stringsFlag
+	var toStdout bool
+	var once bool
+	var autoSync bool
+
+	m := uotp.HistoryMonitor{
+		Interval:   time.Minute,
+		Retries:    3,
+		RetryDelay: 5 * time.Second,
+	}
+
+	fs := newFlagSet("monitor", "[flags]", `Poll the use history and deliver each new entry to webhooks, commands or stdout.
+
+Webhooks and commands get each entry as JSON. On stdout, --output json prints
+one JSON object per line and the template gets the fields SerialNumber, At,
+Type, Name and Kind.`)
+	common.register(fs)
+	out.register(fs)
+	fs.StringVar(&cursorFile, "cursor", "", "Path to the cursor (default: next to the configuration file)")
+	fs.DurationVar(&m.Interval, "interval", m.Interval, "Time between polls")
+	fs.StringVar(&period, "period", "1w", "Period to poll: 1w, 1m or 3m")
+	fs.Var(&webhooks, "webhook", "URL to post each new entry to as JSON, can be repeated")
+	fs.Var(&commands, "exec", "Shell command to run with each new entry as JSON on stdin, can be repeated")
+	fs.BoolVar(&toStdout, "stdout", false, "Print each new entry (default if there are no other sinks)")
+	fs.IntVar(&m.Retries, "retries", m.Retries, "Times a failed delivery is tried again")
+	fs.DurationVar(&m.RetryDelay, "retry-delay", m.RetryDelay, "Delay before the first retry, doubled after each one")
+	fs.BoolVar(&m.NotifyExisting, "notify-existing", false, "Deliver the entries already on the server on the first run")
+	fs.BoolVar(&once, "once", false, "Poll once and exit")
+	fs.BoolVar(&autoSync, "autosync", true, "Synchronize time before polling, then every hour")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	var err error
+	m.Period, err = uotp.ParseHistoryPeriod(period)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+	if m.Interval <= 0 {
+		fmt.Fprintln(os.Stderr, "--interval must be positive")
+		return exitUsage
+	}
+	if err = out.parse(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+	if out.output == outputRaw {
+		fmt.Fprintln(os.Stderr, "monitor has no raw output")
+		return exitUsage
+	}
+	out.stream = true
+
+	for _, url := range webhooks {
+		m.Sinks = append(m.Sinks, uotp.NewWebhookSink(url, nil))
+	}
+	for _, command := range commands {
+		name, args := shellCommand(command)
+		m.Sinks = append(m.Sinks, uotp.NewCommandSink(name, args...))
+	}
+	if toStdout || len(m.Sinks) == 0 {
+		m.Sinks = append(m.Sinks, outputSink(&out))
+	}
+
+	conf, _, otp, err := common.open()
+	if err != nil {
+		return loadFailed(err)
+	}
+	// The monitor never saves the configuration.
+	conf.close()
+	m.OTP = otp
+
+	if cursorFile == "" {
+		cursorFile = strings.TrimSuffix(archivePath(common.path(), m.OTP), ".hist") + ".cursor.json"
+	}
+	m.CursorPath = solvePath(cursorFile)
+	if autoSync {
+		m.SyncInterval = time.Hour
+	}
+
+	logger := log.New(os.Stderr, "uotp monitor: ", log.LstdFlags)
+	m.OnError = func(err error) {
+		logger.Println(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if once {
+		_, err = m.Poll(ctx)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitError
+		}
+		return exitOK
+	}
+
+	logger.Printf("polling every %s, cursor in %s", m.Interval, filepath.Clean(m.CursorPath))
+	err = m.Run(ctx)
+	if err != nil && ctx.Err() == nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	return exitOK
+}
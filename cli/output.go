@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"text/template"
+	"time"
+
+	"github.com/RyuaNerin/uotp"
+)
+
+const (
+	outputText     = "text"
+	outputJSON     = "json"
+	outputRaw      = "raw"
+	outputTemplate = "template"
+)
+
+// outputFlags choose how a command prints its result. Only the result is
+// written to stdout; prompts, notices and warnings go to stderr.
+type outputFlags struct {
+	output string
+	format string
+	tmpl   *template.Template
+	// stream writes json on one line per result, for commands that print
+	// results as they come.
+	stream bool
+}
+
+func (o *outputFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&o.output, "output", outputText, "Output: text, json, raw or template")
+	fs.StringVar(&o.format, "format", "", "Go text/template applied to each result, implies --output template")
+}
+
+// parse checks the flags after they were parsed.
+func (o *outputFlags) parse() error {
+	if o.format != "" {
+		if o.output != outputText && o.output != outputTemplate {
+			return fmt.Errorf("--format cannot be used with --output %s", o.output)
+		}
+		o.output = outputTemplate
+	}
+
+	switch o.output {
+	case outputText, outputJSON, outputRaw:
+	case outputTemplate:
+		if o.format == "" {
+			return fmt.Errorf("--output template needs --format")
+		}
+		tmpl, err := template.New("format").Parse(o.format)
+		if err != nil {
+			return fmt.Errorf("--format: %w", err)
+		}
+		o.tmpl = tmpl
+	default:
+		return fmt.Errorf("unknown output %q", o.output)
+	}
+	return nil
+}
+
+// machine reports whether the output is meant for programs.
+func (o *outputFlags) machine() bool {
+	return o.output != outputText
+}
+
+// write prints result, which is a slice when the command has several. json
+// encodes it as one value, the template is applied to each element on its
+// own line, and text and raw are written by the functions of the command.
+// raw is nil when the command has no raw output.
+func (o *outputFlags) write(result interface{}, text, raw func(w io.Writer)) error {
+	w := os.Stdout
+
+	switch o.output {
+	case outputJSON:
+		e := json.NewEncoder(w)
+		e.SetEscapeHTML(false)
+		if !o.stream {
+			e.SetIndent("", "  ")
+		}
+		return e.Encode(result)
+
+	case outputTemplate:
+		v := reflect.ValueOf(result)
+		if v.Kind() != reflect.Slice {
+			v = reflect.ValueOf([]interface{}{result})
+		}
+		for i := 0; i < v.Len(); i++ {
+			if err := o.tmpl.Execute(w, v.Index(i).Interface()); err != nil {
+				return err
+			}
+			fmt.Fprintln(w)
+		}
+		return nil
+
+	case outputRaw:
+		if raw == nil {
+			return fmt.Errorf("the command has no raw output")
+		}
+		raw(w)
+		return nil
+	}
+
+	text(w)
+	return nil
+}
+
+// tokenResult is the output of token and watch.
+type tokenResult struct {
+	Profile      string `json:"profile"`
+	SerialNumber string `json:"serial_number"`
+	Token        string `json:"token"`
+	Digits       string `json:"digits"`
+	// Remaining is how many seconds the token stays valid.
+	Remaining float64   `json:"remaining"`
+	Expires   time.Time `json:"expires"`
+	// TimeOffset is the offset to the server's clock in seconds.
+	TimeOffset   float64    `json:"time_offset"`
+	TimeSource   string     `json:"time_source"`
+	TimeFallback bool       `json:"time_fallback"`
+	TimeSyncedAt *time.Time `json:"time_synced_at"`
+}
+
+func newTokenResult(label string, otp uotp.UOTP) tokenResult {
+	token := otp.Token()
+	account := otp.GetAccount()
+	return tokenResult{
+		Profile:      label,
+		SerialNumber: account.SerialNumber,
+		Token:        token.Code,
+		Digits:       token.Digits,
+		Remaining:    token.Remaining.Seconds(),
+		Expires:      token.Expires,
+		TimeOffset:   timeOffset(account).Seconds(),
+		TimeSource:   orDefault(account.TimeSource, uotp.ServerTimeSource),
+		TimeFallback: account.TimeFallback,
+		TimeSyncedAt: account.TimeSyncedAt,
+	}
+}
+
+// profileResult is the output of issue and profiles list.
+type profileResult struct {
+	Profile      string `json:"profile"`
+	SerialNumber string `json:"serial_number"`
+	Default      bool   `json:"default"`
+}
+
+// resetResult is the output of reset-errors.
+type resetResult struct {
+	Profile      string `json:"profile"`
+	SerialNumber string `json:"serial_number"`
+}
+
+// historySyncResult is the output of history sync.
+type historySyncResult struct {
+	Added    int    `json:"added"`
+	Archived int    `json:"archived"`
+	Archive  string `json:"archive"`
+}
+
+// syncResult is the output of sync. Durations are in seconds.
+type syncResult struct {
+	Offset        float64 `json:"offset"`
+	Uncertainty   float64 `json:"uncertainty"`
+	RTT           float64 `json:"rtt"`
+	Confidence    float64 `json:"confidence"`
+	Samples       int     `json:"samples"`
+	Rejected      int     `json:"rejected"`
+	Source        string  `json:"source"`
+	Authoritative bool    `json:"authoritative"`
+}
+
+// infoResult is the output of info. Durations are in seconds.
+type infoResult struct {
+	Profile         string              `json:"profile"`
+	SerialNumber    string              `json:"serial_number"`
+	OID             int                 `json:"oid"`
+	Partner         string              `json:"partner"`
+	TimeOffset      float64             `json:"time_offset"`
+	TimeUncertainty float64             `json:"time_uncertainty"`
+	TimeSource      string              `json:"time_source"`
+	Device          *uotp.DeviceProfile `json:"device,omitempty"`
+}
@@ -0,0 +1,538 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/RyuaNerin/uotp"
+	"github.com/RyuaNerin/uotp/internal/fileutil"
+)
+
+// defaultProfile is the name of the profile issued or migrated when none is
+// given.
+const defaultProfile = "default"
+
+// envProfile is the name of the profile of an account given by environment
+// variables.
+const envProfile = "env"
+
+var (
+	errNoAccount = errors.New(`no account, issue one with "uotp issue"`)
+	errReadOnly  = errors.New("the configuration is read-only")
+)
+
+// configFile is the format of the configuration file. It is a keyring file
+// with the default profile.
+type configFile struct {
+	Default  string                `json:"default,omitempty"`
+	Accounts []uotp.KeyringAccount `json:"accounts"`
+}
+
+// config is a loaded configuration file. Profiles are the accounts of the
+// keyring, named by their labels.
+type config struct {
+	path    string
+	Default string
+	keyring *uotp.Keyring
+
+	// readOnly is set when the file must never be written, on immutable
+	// file systems. It is not locked either.
+	readOnly bool
+	// lock keeps other runs from updating the file between loading and
+	// saving it, until close.
+	lock *fileutil.Lock
+	// fromEnv is set when the account was given by environment variables.
+	// It is kept in memory only, and the configuration is read-only.
+	fromEnv bool
+}
+
+// envConfig returns a configuration holding only the account given by
+// environment variables, or uotp.ErrNoEnvAccount.
+func envConfig(opts ...uotp.Option) (*config, error) {
+	account, err := uotp.AccountFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	c := &config{
+		Default:  envProfile,
+		keyring:  uotp.NewKeyring(opts...),
+		readOnly: true,
+		fromEnv:  true,
+	}
+	if _, err = c.keyring.Add(envProfile, account); err != nil {
+		return nil, fmt.Errorf("%s: %w", uotp.EnvAccount, err)
+	}
+	return c, nil
+}
+
+// loadConfig locks and reads the configuration file at path. A missing file
+// is an empty configuration, and a configuration with a single account, as
+// written by older versions, is migrated to a profile named "default".
+func loadConfig(path string, readOnly bool, opts ...uotp.Option) (*config, error) {
+	var lock *fileutil.Lock
+	if !readOnly {
+		var err error
+		if lock, err = lockConfig(path); err != nil {
+			return nil, err
+		}
+	}
+
+	c, migrated, err := readConfig(path, opts...)
+	if err != nil {
+		if lock != nil {
+			lock.Unlock()
+		}
+		return nil, err
+	}
+	c.readOnly = readOnly
+	c.lock = lock
+	if !migrated || readOnly {
+		return c, nil
+	}
+
+	if err = c.save(); err != nil {
+		c.close()
+		return nil, fmt.Errorf("failed to migrate the configuration: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "The account in %s has been moved to the profile %q.\n", path, defaultProfile)
+	return c, nil
+}
+
+// warnNoLock warns once that the configuration is not locked.
+var warnNoLock sync.Once
+
+// lockConfig takes the lock of the configuration file at path, which is a
+// file next to it, and tells when it waits for another run.
+func lockConfig(path string) (*fileutil.Lock, error) {
+	lockPath := path + ".lock"
+	lock, err := fileutil.TryLockFile(lockPath)
+	if errors.Is(err, fileutil.ErrLocked) {
+		fmt.Fprintf(os.Stderr, "Waiting for another uotp using %s...\n", path)
+		lock, err = fileutil.LockFile(lockPath)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock the configuration, use --read-only if it cannot be written: %w", err)
+	}
+	if !fileutil.LockSupported {
+		warnNoLock.Do(func() {
+			fmt.Fprintf(os.Stderr, "warning: %s cannot be locked on this platform, concurrent runs may lose each other's changes\n", path)
+		})
+	}
+	return lock, nil
+}
+
+// close releases the lock. Saving afterwards takes it again.
+func (c *config) close() {
+	if c.lock != nil {
+		c.lock.Unlock()
+		c.lock = nil
+	}
+}
+
+// readConfig reads the configuration file at path without migrating it.
+func readConfig(path string, opts ...uotp.Option) (c *config, migrated bool, err error) {
+	c = &config{
+		path:    path,
+		keyring: uotp.NewKeyring(opts...),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	var fields map[string]json.RawMessage
+	if len(strings.TrimSpace(string(data))) > 0 {
+		err = json.Unmarshal(data, &fields)
+		if err != nil {
+			return nil, false, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	if _, ok := fields["accounts"]; !ok && len(fields) > 0 {
+		var account uotp.Account
+		err = json.Unmarshal(data, &account)
+		if err != nil {
+			return nil, false, fmt.Errorf("%s: %w", path, err)
+		}
+		if _, err = c.keyring.Add(defaultProfile, &account); err != nil {
+			return nil, false, fmt.Errorf("%s: %w", path, err)
+		}
+		c.Default = defaultProfile
+		return c, true, nil
+	}
+
+	var f configFile
+	if err = json.Unmarshal(data, &f); err != nil {
+		return nil, false, fmt.Errorf("%s: %w", path, err)
+	}
+	for i := range f.Accounts {
+		_, err = c.keyring.Add(f.Accounts[i].Label, &f.Accounts[i].Account)
+		if err != nil {
+			return nil, false, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	c.Default = f.Default
+
+	return c, false, nil
+}
+
+// save writes the configuration. After close, it only saves the time
+// synchronization, in the file as other runs may have changed it meanwhile.
+func (c *config) save() error {
+	if c.readOnly {
+		return errReadOnly
+	}
+
+	f := c.file()
+	if c.lock == nil {
+		lock, err := lockConfig(c.path)
+		if err != nil {
+			return err
+		}
+		defer lock.Unlock()
+
+		current, _, err := readConfig(c.path)
+		if err != nil {
+			return err
+		}
+		f = current.file()
+		for i := range f.Accounts {
+			if otp, ok := c.keyring.Get(f.Accounts[i].SerialNumber); ok {
+				copyTimeSync(&f.Accounts[i].Account, otp.GetAccount())
+			}
+		}
+	}
+
+	data, err := json.MarshalIndent(&f, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeConfig(c.path, append(data, '\n'))
+}
+
+// file returns the configuration in the format of the file.
+func (c *config) file() configFile {
+	f := configFile{
+		Default:  c.Default,
+		Accounts: []uotp.KeyringAccount{},
+	}
+	for _, label := range c.keyring.Labels() {
+		otp, _ := c.keyring.Get(label)
+		f.Accounts = append(f.Accounts, uotp.KeyringAccount{
+			Label:   label,
+			Account: otp.GetAccount(),
+		})
+	}
+	return f
+}
+
+// copyTimeSync copies the time synchronization of src to dst.
+func copyTimeSync(dst *uotp.Account, src uotp.Account) {
+	dst.TimeDiff = src.TimeDiff
+	dst.TimeOffset = src.TimeOffset
+	dst.TimeSource = src.TimeSource
+	dst.TimeUncertainty = src.TimeUncertainty
+	dst.TimeFallback = src.TimeFallback
+	dst.TimeSyncedAt = src.TimeSyncedAt
+}
+
+// configBackups is how many previous versions of the configuration are kept:
+// path.bak, then path.bak.1 and so on, the newest first.
+const configBackups = 3
+
+// writeConfig replaces the configuration file at path with data, and keeps
+// the previous versions as backups. The file is never half written: losing
+// it would lose the seeds.
+func writeConfig(path string, data []byte) error {
+	// Replace the target of a symbolic link, not the link.
+	if target, err := filepath.EvalSymlinks(path); err == nil {
+		path = target
+	}
+
+	old, err := os.ReadFile(path)
+	switch {
+	case err == nil && !bytes.Equal(old, data):
+		if err = rotateBackups(path); err != nil {
+			return fmt.Errorf("failed to rotate the backups of the configuration: %w", err)
+		}
+		if err = fileutil.WriteFile(path+".bak", old, 0600); err != nil {
+			return fmt.Errorf("failed to back up the configuration: %w", err)
+		}
+	case err != nil && !errors.Is(err, os.ErrNotExist):
+		return err
+	}
+
+	return fileutil.WriteFile(path, data, 0600)
+}
+
+// rotateBackups moves each backup of the configuration at path one place
+// older, dropping the oldest, to make room for a new path.bak.
+func rotateBackups(path string) error {
+	name := func(i int) string {
+		if i == 0 {
+			return path + ".bak"
+		}
+		return fmt.Sprintf("%s.bak.%d", path, i)
+	}
+	for i := configBackups - 1; i > 0; i-- {
+		err := os.Rename(name(i-1), name(i))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// readOnly reports whether the configuration must not be written, from the
+// flag or UOTP_READ_ONLY.
+func readOnly(flagReadOnly bool) bool {
+	if flagReadOnly {
+		return true
+	}
+	env, _ := strconv.ParseBool(os.Getenv("UOTP_READ_ONLY"))
+	return env
+}
+
+// profileName returns the profile given by the flag, or UOTP_PROFILE.
+func profileName(flagProfile string) string {
+	if flagProfile != "" {
+		return flagProfile
+	}
+	return os.Getenv("UOTP_PROFILE")
+}
+
+// profile returns the account of the profile name, which is a label or a
+// serial number. Without a name it is the default profile, or the only one.
+func (c *config) profile(name string) (string, uotp.UOTP, error) {
+	if name == "" {
+		name = c.Default
+	}
+	if name == "" {
+		labels := c.keyring.Labels()
+		switch len(labels) {
+		case 0:
+			return "", nil, fmt.Errorf("%s: %w", c.path, errNoAccount)
+		case 1:
+			name = labels[0]
+		default:
+			return "", nil, fmt.Errorf("no profile selected, use --profile with one of: %s", strings.Join(labels, ", "))
+		}
+	}
+
+	label, ok := c.keyring.Label(name)
+	if !ok {
+		return "", nil, fmt.Errorf("profile %q: %w", name, uotp.ErrAccountNotFound)
+	}
+	otp, _ := c.keyring.Get(label)
+	return label, otp, nil
+}
+
+// profileCommands are the subcommands of "profiles".
+var profileCommands = map[string]struct {
+	args     int
+	synopsis string
+	help     string
+}{
+	"list":    {0, "[flags]", "List the profiles. The default profile is marked with *."},
+	"add":     {1, "[flags] NAME", "Issue a new account, or import one, to the new profile NAME."},
+	"rm":      {1, "[flags] NAME", "Remove the profile NAME, given by name or serial number."},
+	"rename":  {2, "[flags] NAME NEW", "Rename the profile NAME to NEW."},
+	"default": {1, "[flags] NAME", "Make NAME the default profile."},
+}
+
+// profileOutputHelp ends the help of every subcommand of "profiles".
+const profileOutputHelp = `
+
+The json output and the template get the fields Profile, SerialNumber and
+Default of each profile the command lists or changes.`
+
+func runProfiles(args []string) int {
+	cmd, ok := subcommand("profiles", args, "list", "add", "rm", "rename", "default")
+	if !ok {
+		return exitUsage
+	}
+
+	var confPath string
+	var readOnlyFlag bool
+	var out outputFlags
+	var importPath string
+	var setDefault bool
+
+	sub := profileCommands[cmd]
+	fs := newFlagSet("profiles "+cmd, sub.synopsis, sub.help+profileOutputHelp)
+	fs.StringVar(&confPath, "conf", "", confFlagUsage)
+	fs.BoolVar(&readOnlyFlag, "read-only", false, "Never write the configuration file, also set by UOTP_READ_ONLY")
+	out.register(fs)
+	if cmd == "add" {
+		fs.StringVar(&importPath, "import", "", "Import the account from a configuration file instead of issuing one")
+		fs.BoolVar(&setDefault, "default", false, "Make the new profile the default")
+	}
+	if code, ok := parseFlags(fs, args[1:]); !ok {
+		return code
+	}
+
+	if fs.NArg() != sub.args {
+		fs.Usage()
+		return exitUsage
+	}
+	if err := out.parse(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+
+	loc := findConf(confPath)
+	c, err := loadConfig(loc.Path, loc.ReadOnly || readOnly(readOnlyFlag))
+	if err != nil {
+		return loadFailed(err)
+	}
+	defer c.close()
+
+	if c.readOnly && cmd != "list" {
+		fmt.Fprintln(os.Stderr, errReadOnly)
+		return exitError
+	}
+
+	var result profileResult
+	var message string
+
+	switch cmd {
+	case "list":
+		results := []profileResult{}
+		for _, label := range c.keyring.Labels() {
+			otp, _ := c.keyring.Get(label)
+			results = append(results, profileResult{
+				Profile:      label,
+				SerialNumber: otp.GetSerialNumber(),
+				Default:      label == c.Default,
+			})
+		}
+
+		err = out.write(results, func(w io.Writer) {
+			for _, r := range results {
+				mark := " "
+				if r.Default {
+					mark = "*"
+				}
+				fmt.Fprintf(w, "%s %s\t%s\n", mark, r.Profile, r.SerialNumber)
+			}
+		}, func(w io.Writer) {
+			for _, r := range results {
+				fmt.Fprintln(w, r.Profile)
+			}
+		})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitError
+		}
+		return exitOK
+
+	case "add":
+		label := fs.Arg(0)
+		var otp uotp.UOTP
+		if importPath != "" {
+			imported, _, err := readConfig(solvePath(importPath))
+			if err == nil {
+				_, otp, err = imported.profile("")
+			}
+			if err == nil {
+				account := otp.GetAccount()
+				otp, err = c.keyring.Add(label, &account)
+			}
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "failed to import the account:", err)
+				return exitError
+			}
+		} else {
+			ctx := context.Background()
+			if _, err = c.keyring.SyncTime(ctx); err == nil {
+				otp, err = c.keyring.Issue(ctx, label)
+			}
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "failed to issue an account:", err)
+				return exitError
+			}
+		}
+		if setDefault || c.Default == "" {
+			c.Default = label
+		}
+		result = profileResult{Profile: label, SerialNumber: otp.GetSerialNumber()}
+		message = fmt.Sprintf("Profile %s has been added. Serial Number: %s", label, result.SerialNumber)
+		err = c.save()
+
+	case "rm":
+		label, ok := c.keyring.Label(fs.Arg(0))
+		if !ok {
+			fmt.Fprintf(os.Stderr, "profile %q not found\n", fs.Arg(0))
+			return exitNoAccount
+		}
+		otp, _ := c.keyring.Get(label)
+		result = profileResult{Profile: label, SerialNumber: otp.GetSerialNumber()}
+		message = fmt.Sprintf("Profile %s has been removed.", label)
+		c.keyring.Remove(label)
+		if c.Default == label {
+			c.Default = ""
+		}
+		err = c.save()
+
+	case "rename":
+		label, ok := c.keyring.Label(fs.Arg(0))
+		if !ok {
+			fmt.Fprintf(os.Stderr, "profile %q not found\n", fs.Arg(0))
+			return exitNoAccount
+		}
+		if err = c.keyring.Rename(label, fs.Arg(1)); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitError
+		}
+		if c.Default == label {
+			c.Default = fs.Arg(1)
+		}
+		otp, _ := c.keyring.Get(fs.Arg(1))
+		result = profileResult{Profile: fs.Arg(1), SerialNumber: otp.GetSerialNumber()}
+		message = fmt.Sprintf("Profile %s has been renamed to %s.", label, fs.Arg(1))
+		err = c.save()
+
+	case "default":
+		label, ok := c.keyring.Label(fs.Arg(0))
+		if !ok {
+			fmt.Fprintf(os.Stderr, "profile %q not found\n", fs.Arg(0))
+			return exitNoAccount
+		}
+		c.Default = label
+		otp, _ := c.keyring.Get(label)
+		result = profileResult{Profile: label, SerialNumber: otp.GetSerialNumber()}
+		message = fmt.Sprintf("Profile %s is now the default.", label)
+		err = c.save()
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to save the configuration:", err)
+		return exitError
+	}
+
+	result.Default = c.Default == result.Profile && cmd != "rm"
+	err = out.write(result, func(w io.Writer) {
+		fmt.Fprintln(w, message)
+	}, func(w io.Writer) {
+		fmt.Fprintln(w, result.Profile)
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	return exitOK
+}
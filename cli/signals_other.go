@@ -0,0 +1,11 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// dashboardSignals end the dashboard.
+var dashboardSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
@@ -0,0 +1,12 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// dashboardSignals end the dashboard. The keys do not raise them in raw
+// mode, but kill and a closed terminal still do.
+var dashboardSignals = []os.Signal{os.Interrupt, syscall.SIGTERM, syscall.SIGHUP}
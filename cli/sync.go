@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	// defaultSyncTTL is how long a measured offset is used before the time
+	// is synchronized again.
+	defaultSyncTTL = time.Hour
+	// syncStaleAfter is how old an offset is when a warning is shown. The
+	// local clock may have drifted or been set since it was measured.
+	syncStaleAfter = 24 * time.Hour
+)
+
+// syncFlags choose when a command synchronizes the time before using the
+// offset. The time of the last synchronization is saved with the accounts,
+// so commands run in a row share one.
+type syncFlags struct {
+	autoSync bool
+	offline  bool
+	ttl      time.Duration
+}
+
+func (s *syncFlags) register(fs *flag.FlagSet, usage string) {
+	fs.BoolVar(&s.autoSync, "autosync", true, usage)
+	fs.DurationVar(&s.ttl, "sync-ttl", defaultSyncTTL, "Use the saved offset without synchronizing while it is younger than this, 0 to always synchronize")
+}
+
+// registerOffline adds --offline, for the commands that can work without the
+// network.
+func (s *syncFlags) registerOffline(fs *flag.FlagSet) {
+	fs.BoolVar(&s.offline, "offline", false, "Never use the network, only the saved offset")
+}
+
+// needed reports whether the time should be synchronized, given when the
+// offset was last measured.
+func (s *syncFlags) needed(syncedAt time.Time) bool {
+	if !s.autoSync || s.offline {
+		return false
+	}
+	return s.ttl <= 0 || syncedAt.IsZero() || time.Since(syncedAt) >= s.ttl
+}
+
+// sync synchronizes the time of every profile and saves the configuration,
+// unless the saved offset is recent enough. Otherwise it warns when the
+// saved offset is old.
+func (s *syncFlags) sync(ctx context.Context, conf *config) error {
+	syncedAt := conf.keyring.SyncedAt()
+	if !s.needed(syncedAt) {
+		warnStale(syncedAt)
+		return nil
+	}
+
+	if _, err := conf.keyring.SyncTime(ctx); err != nil {
+		return fmt.Errorf("failed to synchronize time: %w", err)
+	}
+	// A read-only configuration keeps the offset for this run only.
+	if err := conf.save(); err != nil && !errors.Is(err, errReadOnly) {
+		return fmt.Errorf("failed to save the configuration: %w", err)
+	}
+	return nil
+}
+
+// warnStale warns when the offset was measured long ago, or never.
+func warnStale(syncedAt time.Time) {
+	switch age := time.Since(syncedAt); {
+	case syncedAt.IsZero():
+		fmt.Fprintln(os.Stderr, `warning: the time of the last synchronization is unknown, run "uotp sync"`)
+	case age >= syncStaleAfter:
+		fmt.Fprintf(os.Stderr, "warning: the time was last synchronized %s ago, run \"uotp sync\"\n", formatAge(age))
+	}
+}
+
+func formatAge(age time.Duration) string {
+	if days := age / (24 * time.Hour); days >= 2 {
+		return fmt.Sprintf("%d days", days)
+	}
+	return age.Round(time.Hour).String()
+}
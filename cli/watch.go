@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/RyuaNerin/uotp"
+	"github.com/RyuaNerin/uotp/internal/term"
+)
+
+const (
+	// watchRefresh is how often the terminal is redrawn.
+	watchRefresh = 200 * time.Millisecond
+	// watchBarWidth is the width of the countdown bar.
+	watchBarWidth = 20
+)
+
+// countdownBar draws how much of the validity of a token remains.
+func countdownBar(remaining time.Duration, width int) string {
+	filled := int(int64(width) * int64(remaining) / int64(uotp.TokenStep))
+	if filled > width {
+		filled = width
+	}
+	if filled < 0 {
+		filled = 0
+	}
+	return strings.Repeat("█", filled) + strings.Repeat("░", width-filled)
+}
+
+// watchStatus is the last synchronization, shown under the tokens.
+type watchStatus struct {
+	mu  sync.Mutex
+	msg string
+}
+
+func (s *watchStatus) set(format string, a ...interface{}) {
+	s.mu.Lock()
+	s.msg = fmt.Sprintf(format, a...)
+	s.mu.Unlock()
+}
+
+func (s *watchStatus) get() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.msg
+}
+
+func runWatch(args []string) int {
+	var common commonFlags
+	var autoSync bool
+	var offline bool
+	var plain bool
+
+	fs := newFlagSet("watch", "[flags]", `Show the current token with a countdown to the next one, redrawn in place.
+Without a profile, every profile is shown. The time is synchronized in
+the background, again when the clock jumps or seems to drift. With
+--offline, the saved offset is used instead.
+
+When stdout is not a terminal, or with --plain, one line is printed per
+token instead. Stop with Ctrl-C.`)
+	common.register(fs)
+	fs.BoolVar(&autoSync, "autosync", true, "Synchronize time in the background")
+	fs.BoolVar(&offline, "offline", false, "Never use the network, only the saved offset")
+	fs.BoolVar(&plain, "plain", false, "Print one line per token even on a terminal")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	conf, err := common.config()
+	if err != nil {
+		return loadFailed(err)
+	}
+
+	// Saving takes the lock again, so that other runs do not wait meanwhile.
+	conf.close()
+
+	labels := conf.keyring.Labels()
+	if name := common.profileName(); name != "" || len(labels) == 0 {
+		label, _, err := conf.profile(name)
+		if err != nil {
+			return loadFailed(err)
+		}
+		labels = []string{label}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var status watchStatus
+	if offline || !autoSync {
+		warnStale(conf.keyring.SyncedAt())
+	} else {
+		m := &uotp.SyncManager{
+			Syncer: conf.keyring,
+			OnSync: func(reason uotp.SyncReason, r *uotp.SyncResult, err error) {
+				if err != nil {
+					status.set("sync failed (%s): %v", reason, err)
+					return
+				}
+				status.set("synced with %s at %s (%s): %s ± %s", r.Source, time.Now().Format("15:04:05"), reason,
+					r.Offset.Round(time.Millisecond), r.Uncertainty.Round(time.Millisecond))
+				if err := conf.save(); err != nil && !errors.Is(err, errReadOnly) {
+					status.set("failed to save the configuration: %v", err)
+				}
+			},
+		}
+		go m.Run(ctx)
+	}
+
+	results := func() []tokenResult {
+		r := make([]tokenResult, len(labels))
+		for i, label := range labels {
+			otp, _ := conf.keyring.Get(label)
+			r[i] = newTokenResult(label, otp)
+		}
+		return r
+	}
+
+	if plain || !term.IsTerminal(int(os.Stdout.Fd())) {
+		watchLines(ctx, os.Stdout, results)
+	} else {
+		watchTerminal(ctx, os.Stdout, results, &status)
+	}
+	return exitOK
+}
+
+// watchLines prints the tokens on one line each time they change.
+func watchLines(ctx context.Context, w io.Writer, results func() []tokenResult) {
+	for {
+		r := results()
+
+		fields := []string{time.Now().Format("15:04:05")}
+		for _, t := range r {
+			fields = append(fields, t.Profile+" "+t.Token)
+		}
+		fmt.Fprintln(w, strings.Join(fields, "\t"))
+
+		// The profiles share the offset, so their tokens change together.
+		timer := time.NewTimer(time.Until(r[0].Expires))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// watchTerminal redraws the tokens in place until ctx is done.
+func watchTerminal(ctx context.Context, w io.Writer, results func() []tokenResult, status *watchStatus) {
+	// Hide the cursor while drawing, and show it again on exit.
+	fmt.Fprint(w, "\x1b[?25l")
+	defer fmt.Fprint(w, "\x1b[?25h\n")
+
+	ticker := time.NewTicker(watchRefresh)
+	defer ticker.Stop()
+
+	lines := 0
+	for {
+		r := results()
+
+		var b strings.Builder
+		if lines > 0 {
+			fmt.Fprintf(&b, "\r\x1b[%dA", lines)
+		}
+		for _, t := range r {
+			remaining := time.Duration(t.Remaining * float64(time.Second))
+			fmt.Fprintf(&b, "\x1b[2K%-12s %s  %s %2.0fs\n", t.Profile, t.Token,
+				countdownBar(remaining, watchBarWidth), math.Ceil(t.Remaining))
+		}
+		fmt.Fprintf(&b, "\x1b[2K%s", status.get())
+		lines = len(r)
+		io.WriteString(w, b.String())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package uotp
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConcurrentUse(t *testing.T) {
+	u := newTestUOTP(t, &fakeServer{t: t, history: testHistoryEntries(3)})
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	run := func(f func() error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				if err := f(); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+
+	run(func() error {
+		u.GenerateToken()
+		return nil
+	})
+	run(func() error {
+		u.GetAccount()
+		u.GetSerialNumber()
+		return nil
+	})
+	run(func() error {
+		_, err := u.SyncTime(ctx)
+		return err
+	})
+	run(func() error {
+		_, err := u.GetHistory(ctx, 1)
+		return err
+	})
+	run(func() error {
+		return u.ResetErrorCount(ctx)
+	})
+	run(func() error {
+		return u.Issue(ctx)
+	})
+	wg.Wait()
+}
+
+func TestSyncTimeCoalesced(t *testing.T) {
+	f := &fakeServer{t: t, block: make(chan struct{}), entered: make(chan struct{}, 1)}
+	u := newTestUOTP(t, f, WithSyncSamples(1))
+
+	const n = 10
+	var started sync.WaitGroup
+	results := make(chan *SyncResult, n)
+	for i := 0; i < n; i++ {
+		started.Add(1)
+		go func() {
+			started.Done()
+			r, err := u.SyncTime(context.Background())
+			if err != nil {
+				t.Error(err)
+			}
+			results <- r
+		}()
+	}
+
+	// Give the calls time to join the one in flight.
+	<-f.entered
+	started.Wait()
+	time.Sleep(50 * time.Millisecond)
+	close(f.block)
+
+	var first *SyncResult
+	for i := 0; i < n; i++ {
+		r := <-results
+		if first == nil {
+			first = r
+		} else if r == first || r.Offset != first.Offset {
+			t.Errorf("results %p %+v and %p %+v", r, r, first, first)
+		}
+	}
+	if n := f.count(); n != 1 {
+		t.Errorf("%d requests, want 1", n)
+	}
+}
+
+func TestSyncTimeIssue(t *testing.T) {
+	// The server's clock is an hour ahead at the first request, which is
+	// held while Issue runs, and two hours afterwards.
+	clock := time.Date(2022, 3, 1, 12, 0, 0, 0, serverLocation)
+	f := &fakeServer{
+		t:       t,
+		clock:   &clock,
+		block:   make(chan struct{}),
+		entered: make(chan struct{}, 1),
+		skew: func(i int) time.Duration {
+			if i == 0 {
+				return time.Hour
+			}
+			return 2 * time.Hour
+		},
+	}
+	u := newTestUOTP(t, f, WithSyncSamples(1))
+
+	synced := make(chan *SyncResult)
+	go func() {
+		r, err := u.SyncTime(context.Background())
+		if err != nil {
+			t.Error(err)
+		}
+		synced <- r
+	}()
+	<-f.entered
+
+	if err := u.Issue(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	close(f.block)
+
+	// The offset measured before Issue is dropped, and measured again.
+	r := <-synced
+	if d := r.Offset - 2*time.Hour; d < 0 || time.Second < d {
+		t.Errorf("offset %s, want 2h", r.Offset)
+	}
+	if u.offset() != r.Offset {
+		t.Errorf("offset %s applied, want %s", u.offset(), r.Offset)
+	}
+	if n := f.count(); n != 3 {
+		t.Errorf("%d requests, want 3", n)
+	}
+}
+
+func TestSyncTimeCoalescedCancel(t *testing.T) {
+	f := &fakeServer{t: t, block: make(chan struct{}), entered: make(chan struct{}, 1)}
+	u := newTestUOTP(t, f, WithSyncSamples(1))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	leader := make(chan error)
+	go func() {
+		_, err := u.SyncTime(ctx)
+		leader <- err
+	}()
+	<-f.entered
+
+	waiter := make(chan error)
+	go func() {
+		_, err := u.SyncTime(context.Background())
+		waiter <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	// The waiter starts over when the leader gives up.
+	cancel()
+	if err := <-leader; err != context.Canceled {
+		t.Errorf("leader: %v", err)
+	}
+	<-f.entered
+	close(f.block)
+	if err := <-waiter; err != nil {
+		t.Errorf("waiter: %v", err)
+	}
+	if n := f.count(); n != 2 {
+		t.Errorf("%d requests, want 2", n)
+	}
+}
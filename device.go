@@ -0,0 +1,49 @@
+package uotp
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// DeviceProfile describes the phone an account presents itself as.
+// It is sent in the common header of every request except time sync.
+type DeviceProfile struct {
+	Carrier    string `json:"carrier"`     // up to 3 characters, e.g. "SKT"
+	Model      string `json:"model"`       // up to 16 characters, e.g. "SM-G950S"
+	AppVersion string `json:"app_version"` // up to 4 characters, e.g. "GA15"
+	OSType     int    `json:"os_type"`     // 0 ~ 9999, 2 for Android
+	OSVersion  int    `json:"os_version"`  // 0 ~ 9999
+}
+
+// DefaultDeviceProfiles are the profiles picked from when an account has none.
+var DefaultDeviceProfiles = []DeviceProfile{
+	{Carrier: "KTF", Model: "SM-G920K", AppVersion: "GA15", OSType: 2, OSVersion: 0},
+	{Carrier: "SKT", Model: "SM-G950S", AppVersion: "GA15", OSType: 2, OSVersion: 0},
+	{Carrier: "LGT", Model: "SM-G955L", AppVersion: "GA15", OSType: 2, OSVersion: 0},
+}
+
+// RandomDeviceProfile picks one of DefaultDeviceProfiles using r.
+// If r is nil, a time seeded source is used.
+func RandomDeviceProfile(r *rand.Rand) DeviceProfile {
+	if r == nil {
+		r = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	return DefaultDeviceProfiles[r.Intn(len(DefaultDeviceProfiles))]
+}
+
+func (d *DeviceProfile) validate() error {
+	switch {
+	case len(d.Carrier) > 3:
+		return fmt.Errorf("carrier %q is longer than 3 characters", d.Carrier)
+	case len(d.Model) > 16:
+		return fmt.Errorf("model %q is longer than 16 characters", d.Model)
+	case len(d.AppVersion) > 4:
+		return fmt.Errorf("app version %q is longer than 4 characters", d.AppVersion)
+	case d.OSType < 0 || 9999 < d.OSType:
+		return fmt.Errorf("os type %d is out of range", d.OSType)
+	case d.OSVersion < 0 || 9999 < d.OSVersion:
+		return fmt.Errorf("os version %d is out of range", d.OSVersion)
+	}
+	return nil
+}
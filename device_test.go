@@ -0,0 +1,66 @@
+package uotp
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math/rand"
+	"testing"
+
+	"github.com/RyuaNerin/uotp/protocol"
+)
+
+func TestCommonHeader(t *testing.T) {
+	answer := []byte{
+		0x4B, 0x54, 0x46, 0x20, 0x20, 0x20, 0x20, 0x20, 0x20, 0x20, 0x20, 0x20, 0x20, 0x20, 0x53, 0x4D,
+		0x2D, 0x47, 0x39, 0x35, 0x35, 0x4C, 0x20, 0x20, 0x20, 0x20, 0x20, 0x20, 0x20, 0x20, 0x47, 0x41,
+		0x31, 0x35, 0x30, 0x30, 0x30, 0x32, 0x30, 0x30, 0x30, 0x30,
+	}
+
+	p := newPacket(protocol.OpIssue)
+	p.device = &DeviceProfile{
+		Carrier:    "KTF",
+		Model:      "SM-G955L",
+		AppVersion: "GA15",
+		OSType:     2,
+		OSVersion:  0,
+	}
+
+	header, err := p.appendCommonHeader(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(header, answer) {
+		t.Errorf("header is not matched\n%s", hex.Dump(header))
+	}
+}
+
+func TestDeviceProfileIsStable(t *testing.T) {
+	a, err := New(nil, WithRand(rand.New(rand.NewSource(1))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := New(nil, WithRand(rand.New(rand.NewSource(1))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *a.GetAccount().Device != *b.GetAccount().Device {
+		t.Errorf("same source picked different profiles: %+v, %+v", a.GetAccount().Device, b.GetAccount().Device)
+	}
+
+	account := a.GetAccount()
+	c, err := New(&account, WithRand(rand.New(rand.NewSource(2))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *c.GetAccount().Device != *account.Device {
+		t.Errorf("saved profile was not kept: %+v, %+v", c.GetAccount().Device, account.Device)
+	}
+}
+
+func TestDeviceProfileValidation(t *testing.T) {
+	_, err := New(nil, WithDeviceProfile(DeviceProfile{Carrier: "KTFX"}))
+	if err == nil {
+		t.Error("expected an error for a carrier longer than 3 characters")
+	}
+}
@@ -40,7 +40,7 @@ func main() {
 	fmt.Println("Serial Number:", otp.GetSerialNumber())
 
 	// Sync time with the server
-	err := otp.SyncTime(context.Background())
+	_, err := otp.SyncTime(context.Background())
 	if err != nil {
 		panic(err)
 	}
@@ -0,0 +1,54 @@
+package uotp
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/RyuaNerin/uotp/protocol"
+)
+
+func fuzzPayload(f *testing.F, opcode protocol.OpCode, seeds ...string) {
+	for _, s := range seeds {
+		f.Add([]byte(s))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		newPayload(opcode).decode(data)
+	})
+}
+
+func FuzzDecodeTime(f *testing.F) {
+	fuzzPayload(f, protocol.OpTime, "\x00\x00\x00\x01", "")
+}
+
+func FuzzDecodeIssue(f *testing.F) {
+	fuzzPayload(
+		f,
+		protocol.OpIssue,
+		"178453656261        00630981781ba9004ad3ffcce3498808ab6a65e4c8a8aaef6bb99eb850e6bd360717a98bbb72b8b302206039"+
+			"95d2b02aa837fcbf5b0460d7614"+strings.Repeat(" ", 87),
+		"",
+	)
+}
+
+func FuzzDecodeInformation(f *testing.F) {
+	fuzzPayload(f, protocol.OpInformation, "12345678901"+strings.Repeat(" ", 120), "")
+}
+
+func FuzzDecodeHistory(f *testing.F) {
+	fuzzPayload(
+		f,
+		protocol.OpUseHistory,
+		"2022-01-012022-03-310001000101"+"2022-03-0112:34:56"+strings.Repeat(" ", 80),
+		"2022-01-012022-03-3100010001-1",
+		"",
+	)
+}
+
+func FuzzDecodeHelp(f *testing.F) {
+	fuzzPayload(f, protocol.OpHelp, "a|b|c00000000", "")
+}
+
+func FuzzDecodeResetErrorCount(f *testing.F) {
+	fuzzPayload(f, protocol.OpResetErrorCount, "")
+}
@@ -0,0 +1,125 @@
+package uotp
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// HistoryPeriod is how far back the server looks for history entries.
+type HistoryPeriod int
+
+// The periods offered by the official app.
+const (
+	HistoryPeriodOneWeek     HistoryPeriod = 1
+	HistoryPeriodOneMonth    HistoryPeriod = 2
+	HistoryPeriodThreeMonths HistoryPeriod = 3
+)
+
+func (p HistoryPeriod) valid() bool {
+	return HistoryPeriodOneWeek <= p && p <= HistoryPeriodThreeMonths
+}
+
+func (p HistoryPeriod) String() string {
+	switch p {
+	case HistoryPeriodOneWeek:
+		return "1w"
+	case HistoryPeriodOneMonth:
+		return "1m"
+	case HistoryPeriodThreeMonths:
+		return "3m"
+	}
+	return "invalid"
+}
+
+// ParseHistoryPeriod parses the text returned by HistoryPeriod.String.
+func ParseHistoryPeriod(s string) (HistoryPeriod, error) {
+	for p := HistoryPeriodOneWeek; p <= HistoryPeriodThreeMonths; p++ {
+		if p.String() == s {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %q", ErrInvalidPeriod, s)
+}
+
+// HistoryIterator walks every entry of every history page.
+//
+//	it := otp.HistoryAll(ctx, uotp.HistoryPeriodOneMonth)
+//	for it.Next() {
+//		entry := it.Entry()
+//	}
+//	if err := it.Err(); err != nil {
+//	}
+type HistoryIterator struct {
+	ctx    context.Context
+	u      *uotp
+	period HistoryPeriod
+
+	page     *History
+	nextPage int
+	index    int
+	entry    HistoryEntry
+	err      error
+
+	PeriodStart time.Time
+	PeriodEnd   time.Time
+}
+
+// Next advances to the next entry, fetching the next page when needed.
+// It returns false when there are no more entries or an error occurred.
+func (it *HistoryIterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+
+	for it.page == nil || it.index >= len(it.page.Entries) {
+		if it.page != nil && (it.nextPage > it.page.PageTotal || len(it.page.Entries) == 0) {
+			return false
+		}
+		if it.nextPage == 0 {
+			it.nextPage = 1
+		}
+
+		if it.err = it.ctx.Err(); it.err != nil {
+			return false
+		}
+
+		it.page, it.err = it.u.GetHistoryPeriod(it.ctx, it.nextPage, it.period)
+		if it.err != nil {
+			return false
+		}
+		it.index = 0
+		it.nextPage++
+
+		if it.nextPage == 2 {
+			it.PeriodStart = it.page.PeriodStart
+			it.PeriodEnd = it.page.PeriodEnd
+		}
+	}
+
+	if it.err = it.ctx.Err(); it.err != nil {
+		return false
+	}
+
+	it.entry = it.page.Entries[it.index]
+	it.index++
+	return true
+}
+
+// Entry returns the current entry.
+func (it *HistoryIterator) Entry() HistoryEntry {
+	return it.entry
+}
+
+// Page returns the page the current entry is on.
+func (it *HistoryIterator) Page() int {
+	if it.page == nil {
+		return 0
+	}
+	return it.page.PageCurrent
+}
+
+// Err returns the error that stopped the iteration, if any.
+func (it *HistoryIterator) Err() error {
+	return it.err
+}
@@ -0,0 +1,404 @@
+package uotp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/RyuaNerin/uotp/internal/fileutil"
+)
+
+// historyArchiveMagic starts every archive file. The last byte is the version.
+var historyArchiveMagic = []byte("UOTPHIST\x01")
+
+// historyArchiveMaxRecord bounds a record so a corrupt length cannot make
+// OpenHistoryArchive allocate without limit.
+const historyArchiveMaxRecord = 1024
+
+var ErrInvalidArchive = errors.New("invalid history archive")
+
+// historyKey identifies an entry by the time the server wrote, its wall time
+// in the zone it was read in, rather than by the instant. Archives written
+// before the times were read in serverLocation hold them in the zone the
+// host had then.
+type historyKey struct {
+	wall int64
+	typ  string
+	name string
+}
+
+func historyKeyOf(e HistoryEntry) historyKey {
+	_, offset := e.At.Zone()
+	return historyKey{e.At.Unix() + int64(offset), e.Type, e.Name}
+}
+
+// HistoryArchive is a local append-only copy of the history of one account.
+// It keeps entries after the server stops returning them.
+//
+// Each record holds the time, its zone offset, the type and the name,
+// as varints and length-prefixed strings.
+type HistoryArchive struct {
+	path    string
+	size    int64
+	entries []HistoryEntry
+	seen    map[historyKey]struct{}
+}
+
+// OpenHistoryArchive reads the archive at path. A missing file is an empty
+// archive; it is created by the first Append.
+//
+// A record cut short at the end of the file by an interrupted write is
+// ignored and overwritten by the next Append. Any other damage is
+// ErrInvalidArchive, so that Append never writes over the records after it.
+func OpenHistoryArchive(path string) (*HistoryArchive, error) {
+	a := &HistoryArchive{
+		path: path,
+		seen: make(map[historyKey]struct{}),
+	}
+
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return a, nil
+	}
+
+	// Without the lock, as on a read-only file system where Append fails
+	// anyway, a record being appended is read as cut short.
+	if lock, err := fileutil.LockFile(a.lockPath()); err == nil {
+		defer lock.Unlock()
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if err = a.load(f); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// lockPath returns the lock file that Append holds while it reads and
+// writes the archive, shared by every process using it.
+func (a *HistoryArchive) lockPath() string {
+	return a.path + ".lock"
+}
+
+// load reads the records of f after the first a.size bytes, which are
+// already read. A record cut short at the end of the file is left out of
+// a.size.
+func (a *HistoryArchive) load(f *os.File) error {
+	if _, err := f.Seek(a.size, io.SeekStart); err != nil {
+		return err
+	}
+	r := bufio.NewReader(f)
+
+	if a.size == 0 {
+		magic := make([]byte, len(historyArchiveMagic))
+		_, err := io.ReadFull(r, magic)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil || !bytes.Equal(magic, historyArchiveMagic) {
+			return fmt.Errorf("%w: %s: bad header", ErrInvalidArchive, a.path)
+		}
+		a.size = int64(len(magic))
+	}
+
+	var buf []byte
+	for {
+		n, err := binary.ReadUvarint(r)
+		switch {
+		case err == io.EOF, err == io.ErrUnexpectedEOF:
+			return nil
+		case err != nil:
+			return fmt.Errorf("%w: %s: bad record length at %d: %v", ErrInvalidArchive, a.path, a.size, err)
+		case n > historyArchiveMaxRecord:
+			return fmt.Errorf("%w: %s: record of %d bytes at %d", ErrInvalidArchive, a.path, n, a.size)
+		}
+
+		if cap(buf) < int(n) {
+			buf = make([]byte, n)
+		}
+		buf = buf[:n]
+		if _, err = io.ReadFull(r, buf); err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		entry, err := decodeHistoryRecord(buf)
+		if err != nil {
+			return fmt.Errorf("%w: %s: record at %d: %v", ErrInvalidArchive, a.path, a.size, err)
+		}
+		a.add(entry)
+		a.size += int64(uvarintLen(n)) + int64(n)
+	}
+}
+
+func appendVarint(dst []byte, v int64) []byte {
+	var b [binary.MaxVarintLen64]byte
+	return append(dst, b[:binary.PutVarint(b[:], v)]...)
+}
+
+func appendUvarint(dst []byte, v uint64) []byte {
+	var b [binary.MaxVarintLen64]byte
+	return append(dst, b[:binary.PutUvarint(b[:], v)]...)
+}
+
+func uvarintLen(v uint64) int {
+	var b [binary.MaxVarintLen64]byte
+	return binary.PutUvarint(b[:], v)
+}
+
+func encodeHistoryRecord(dst []byte, e HistoryEntry) []byte {
+	_, offset := e.At.Zone()
+
+	var rec []byte
+	rec = appendVarint(rec, e.At.Unix())
+	rec = appendVarint(rec, int64(offset))
+	rec = appendUvarint(rec, uint64(len(e.Type)))
+	rec = append(rec, e.Type...)
+	rec = appendUvarint(rec, uint64(len(e.Name)))
+	rec = append(rec, e.Name...)
+
+	dst = appendUvarint(dst, uint64(len(rec)))
+	return append(dst, rec...)
+}
+
+func decodeHistoryRecord(rec []byte) (HistoryEntry, error) {
+	var e HistoryEntry
+
+	unix, n := binary.Varint(rec)
+	if n <= 0 {
+		return e, errors.New("bad time")
+	}
+	rec = rec[n:]
+
+	offset, n := binary.Varint(rec)
+	if n <= 0 || offset < -24*60*60 || 24*60*60 < offset {
+		return e, errors.New("bad zone offset")
+	}
+	rec = rec[n:]
+
+	readString := func() (string, bool) {
+		l, n := binary.Uvarint(rec)
+		if n <= 0 || uint64(len(rec)-n) < l {
+			return "", false
+		}
+		s := string(rec[n : n+int(l)])
+		rec = rec[n+int(l):]
+		return s, true
+	}
+
+	var ok bool
+	if e.Type, ok = readString(); !ok {
+		return e, errors.New("bad type")
+	}
+	if e.Name, ok = readString(); !ok {
+		return e, errors.New("bad name")
+	}
+	if len(rec) != 0 {
+		return e, errors.New("trailing bytes")
+	}
+
+	e.At = time.Unix(unix, 0).In(zoneFor(int(offset)))
+	return e, nil
+}
+
+// zoneFor returns serverLocation if it has offset, so archived times read
+// back the same way the server's are parsed.
+func zoneFor(offset int) *time.Location {
+	if _, server := time.Now().In(serverLocation).Zone(); server == offset {
+		return serverLocation
+	}
+	return time.FixedZone("", offset)
+}
+
+func (a *HistoryArchive) add(e HistoryEntry) bool {
+	key := historyKeyOf(e)
+	if _, ok := a.seen[key]; ok {
+		return false
+	}
+	a.seen[key] = struct{}{}
+	a.entries = append(a.entries, e)
+	return true
+}
+
+// Path returns the file the archive is stored in.
+func (a *HistoryArchive) Path() string {
+	return a.path
+}
+
+// Len returns the number of archived entries.
+func (a *HistoryArchive) Len() int {
+	return len(a.entries)
+}
+
+// Contains reports whether an entry with the same time, type and name is archived.
+func (a *HistoryArchive) Contains(e HistoryEntry) bool {
+	_, ok := a.seen[historyKeyOf(e)]
+	return ok
+}
+
+// Entries returns every archived entry, oldest first.
+func (a *HistoryArchive) Entries() []HistoryEntry {
+	entries := make([]HistoryEntry, len(a.entries))
+	copy(entries, a.entries)
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].At.Before(entries[j].At)
+	})
+	return entries
+}
+
+// fresh returns the entries that are not archived yet, once each, oldest
+// first.
+func (a *HistoryArchive) fresh(entries []HistoryEntry) []HistoryEntry {
+	var fresh []HistoryEntry
+	seen := make(map[historyKey]struct{})
+	for _, e := range entries {
+		key := historyKeyOf(e)
+		if _, ok := seen[key]; ok || a.Contains(e) {
+			continue
+		}
+		seen[key] = struct{}{}
+		fresh = append(fresh, e)
+	}
+	sort.SliceStable(fresh, func(i, j int) bool {
+		return fresh[i].At.Before(fresh[j].At)
+	})
+	return fresh
+}
+
+// Append writes the entries that are not archived yet, oldest first, and
+// returns how many were written. It first reads the records that other
+// processes appended since the archive was read, under a lock they share.
+func (a *HistoryArchive) Append(entries ...HistoryEntry) (int, error) {
+	if len(a.fresh(entries)) == 0 {
+		return 0, nil
+	}
+
+	lock, err := fileutil.LockFile(a.lockPath())
+	if err != nil {
+		return 0, err
+	}
+	defer lock.Unlock()
+
+	f, err := os.OpenFile(a.path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	if err = a.load(f); err != nil {
+		return 0, err
+	}
+	fresh := a.fresh(entries)
+	if len(fresh) == 0 {
+		return 0, nil
+	}
+
+	var data []byte
+	if a.size == 0 {
+		data = append(data, historyArchiveMagic...)
+	}
+	for _, e := range fresh {
+		data = encodeHistoryRecord(data, e)
+	}
+
+	// Drop a record cut short by an earlier interrupted write.
+	if err = f.Truncate(a.size); err != nil {
+		return 0, err
+	}
+	if _, err = f.WriteAt(data, a.size); err != nil {
+		return 0, err
+	}
+	if err = f.Sync(); err != nil {
+		return 0, err
+	}
+	if err = f.Close(); err != nil {
+		return 0, err
+	}
+
+	a.size += int64(len(data))
+	for _, e := range fresh {
+		a.add(e)
+	}
+	return len(fresh), nil
+}
+
+// Sync fetches history pages, newest first, until a page holds an entry that
+// is already archived, and appends the new entries. It returns how many
+// entries were added.
+func (a *HistoryArchive) Sync(ctx context.Context, otp UOTP, period HistoryPeriod) (int, error) {
+	var fresh []HistoryEntry
+
+	for page := 1; ; page++ {
+		h, err := otp.GetHistoryPeriod(ctx, page, period)
+		if err != nil {
+			return 0, err
+		}
+
+		known := false
+		for _, e := range h.Entries {
+			if a.Contains(e) {
+				known = true
+			} else {
+				fresh = append(fresh, e)
+			}
+		}
+
+		if known || len(h.Entries) == 0 || page >= h.PageTotal {
+			break
+		}
+	}
+
+	return a.Append(fresh...)
+}
+
+// HistoryFilter selects history entries. Zero fields match everything.
+type HistoryFilter struct {
+	// From and To bound the time of the entry, inclusive.
+	From time.Time
+	To   time.Time
+	// Type and Name match when they are contained in the entry's type and
+	// name, ignoring case.
+	Type string
+	Name string
+}
+
+// Match reports whether e is selected by f.
+func (f *HistoryFilter) Match(e HistoryEntry) bool {
+	if !f.From.IsZero() && e.At.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && e.At.After(f.To) {
+		return false
+	}
+	if f.Type != "" && !strings.Contains(strings.ToLower(e.Type), strings.ToLower(f.Type)) {
+		return false
+	}
+	if f.Name != "" && !strings.Contains(strings.ToLower(e.Name), strings.ToLower(f.Name)) {
+		return false
+	}
+	return true
+}
+
+// Search returns the archived entries selected by f, oldest first.
+func (a *HistoryArchive) Search(f HistoryFilter) []HistoryEntry {
+	var r []HistoryEntry
+	for _, e := range a.Entries() {
+		if f.Match(e) {
+			r = append(r, e)
+		}
+	}
+	return r
+}
@@ -0,0 +1,271 @@
+package uotp
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func reversed(entries []HistoryEntry) []HistoryEntry {
+	r := make([]HistoryEntry, len(entries))
+	for i, e := range entries {
+		r[len(r)-1-i] = e
+	}
+	return r
+}
+
+func TestHistoryArchiveRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history", "archive.hist")
+	entries := testHistoryEntries(5)
+
+	a, err := OpenHistoryArchive(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := a.Append(entries...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(entries) {
+		t.Errorf("appended %d, want %d", n, len(entries))
+	}
+
+	n, err = a.Append(entries[1], entries[1])
+	if err != nil || n != 0 {
+		t.Errorf("append duplicate = %d, %v", n, err)
+	}
+
+	a, err = OpenHistoryArchive(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := a.Entries(); !reflect.DeepEqual(got, reversed(entries)) {
+		t.Errorf("got %v, want %v", got, reversed(entries))
+	}
+}
+
+func TestHistoryArchiveTruncatedTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "archive.hist")
+	entries := testHistoryEntries(3)
+
+	a, err := OpenHistoryArchive(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = a.Append(entries[2], entries[1]); err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Truncate(path, fi.Size()-3); err != nil {
+		t.Fatal(err)
+	}
+
+	a, err = OpenHistoryArchive(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Len() != 1 {
+		t.Fatalf("got %d entries after truncation, want 1", a.Len())
+	}
+
+	if _, err = a.Append(entries...); err != nil {
+		t.Fatal(err)
+	}
+	a, err = OpenHistoryArchive(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := a.Entries(); !reflect.DeepEqual(got, reversed(entries)) {
+		t.Errorf("got %v, want %v", got, reversed(entries))
+	}
+}
+
+func TestHistoryArchiveBadHeader(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "archive.hist")
+	if err := os.WriteFile(path, []byte("not an archive"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := OpenHistoryArchive(path)
+	if !errors.Is(err, ErrInvalidArchive) {
+		t.Errorf("got %v, want ErrInvalidArchive", err)
+	}
+}
+
+func TestHistoryArchiveCorruptLength(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "archive.hist")
+	// Enough entries that a longer record still ends before the file.
+	entries := testHistoryEntries(50)
+
+	a, err := OpenHistoryArchive(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = a.Append(entries...); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The length of the second record, past the header and the first one.
+	first := len(historyArchiveMagic)
+	second := first + 1 + int(data[first])
+	for _, b := range []byte{0xff, 0x7f, data[second] - 1, data[second] + 1} {
+		corrupt := append([]byte(nil), data...)
+		corrupt[second] = b
+		if err = os.WriteFile(path, corrupt, 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err = OpenHistoryArchive(path); !errors.Is(err, ErrInvalidArchive) {
+			t.Errorf("length %#x: got %v, want ErrInvalidArchive", b, err)
+		}
+	}
+}
+
+func TestHistoryArchiveConcurrentAppend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "archive.hist")
+	entries := testHistoryEntries(6)
+
+	a, err := OpenHistoryArchive(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := OpenHistoryArchive(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each appends while the other's records are unknown to it.
+	if _, err = a.Append(entries[0], entries[1]); err != nil {
+		t.Fatal(err)
+	}
+	n, err := b.Append(entries[1], entries[2], entries[3])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("appended %d, want 2 as one was appended by the other", n)
+	}
+	if _, err = a.Append(entries[4], entries[5]); err != nil {
+		t.Fatal(err)
+	}
+	if a.Len() != len(entries) {
+		t.Errorf("got %d entries, want %d", a.Len(), len(entries))
+	}
+
+	a, err = OpenHistoryArchive(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := a.Entries(); !reflect.DeepEqual(got, reversed(entries)) {
+		t.Errorf("got %v, want %v", got, reversed(entries))
+	}
+}
+
+func TestHistoryArchiveSync(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "archive.hist")
+	entries := testHistoryEntries(25)
+
+	a, err := OpenHistoryArchive(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var pages []int
+	u := newTestUOTP(t, &fakeServer{t: t, history: entries[5:], onHistory: func(page int, period HistoryPeriod) {
+		pages = append(pages, page)
+	}})
+	n, err := a.Sync(context.Background(), u, HistoryPeriodThreeMonths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 20 || !reflect.DeepEqual(pages, []int{1, 2}) {
+		t.Errorf("first sync added %d from pages %v", n, pages)
+	}
+
+	// Five newer entries; the oldest ones rolled out of the server's window.
+	pages = nil
+	u = newTestUOTP(t, &fakeServer{t: t, history: entries[:15], onHistory: func(page int, period HistoryPeriod) {
+		pages = append(pages, page)
+	}})
+	n, err = a.Sync(context.Background(), u, HistoryPeriodThreeMonths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 5 || !reflect.DeepEqual(pages, []int{1}) {
+		t.Errorf("second sync added %d from pages %v", n, pages)
+	}
+
+	a, err = OpenHistoryArchive(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := a.Entries(); !reflect.DeepEqual(got, reversed(entries)) {
+		t.Errorf("archive has %d entries, want %d", len(got), len(entries))
+	}
+}
+
+// TestHistoryArchiveHostZone checks that entries archived in the zone of the
+// host, as older versions read them, are not archived again once read in the
+// zone of the server.
+func TestHistoryArchiveHostZone(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "archive.hist")
+	host := time.FixedZone("EST", -5*60*60)
+
+	a, err := OpenHistoryArchive(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := HistoryEntry{At: time.Date(2022, 3, 1, 12, 0, 0, 0, host), Type: "인증", Name: "서비스"}
+	if _, err = a.Append(old); err != nil {
+		t.Fatal(err)
+	}
+
+	a, err = OpenHistoryArchive(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := HistoryEntry{At: time.Date(2022, 3, 1, 12, 0, 0, 0, serverLocation), Type: "인증", Name: "서비스"}
+	if n, err := a.Append(e); err != nil || n != 0 {
+		t.Errorf("append = %d, %v, want the entry archived already", n, err)
+	}
+	if a.Len() != 1 {
+		t.Errorf("archive has %d entries, want 1", a.Len())
+	}
+}
+
+func TestHistoryFilter(t *testing.T) {
+	base := time.Date(2022, 3, 1, 12, 0, 0, 0, serverLocation)
+	e := HistoryEntry{At: base, Type: "OTP 인증", Name: "Example Bank"}
+
+	tests := []struct {
+		filter HistoryFilter
+		want   bool
+	}{
+		{HistoryFilter{}, true},
+		{HistoryFilter{From: base}, true},
+		{HistoryFilter{From: base.Add(time.Second)}, false},
+		{HistoryFilter{To: base}, true},
+		{HistoryFilter{To: base.Add(-time.Second)}, false},
+		{HistoryFilter{Type: "otp"}, true},
+		{HistoryFilter{Type: "발급"}, false},
+		{HistoryFilter{Name: "bank"}, true},
+		{HistoryFilter{Name: "bank", Type: "발급"}, false},
+	}
+	for i, tt := range tests {
+		if got := tt.filter.Match(e); got != tt.want {
+			t.Errorf("%d: Match = %v, want %v", i, got, tt.want)
+		}
+	}
+}
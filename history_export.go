@@ -0,0 +1,189 @@
+package uotp
+
+import (
+	"crypto/sha1"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// HistoryFormat is a format history entries can be exported to.
+type HistoryFormat string
+
+const (
+	HistoryFormatCSV   HistoryFormat = "csv"
+	HistoryFormatJSONL HistoryFormat = "jsonl"
+	HistoryFormatICS   HistoryFormat = "ics"
+)
+
+// HistoryEncoder writes history entries to an output stream.
+// Close must be called after the last entry to complete the output.
+type HistoryEncoder interface {
+	Encode(entry HistoryEntry) error
+	Close() error
+}
+
+// NewHistoryEncoder returns an encoder writing format to w.
+func NewHistoryEncoder(w io.Writer, format HistoryFormat) (HistoryEncoder, error) {
+	switch format {
+	case HistoryFormatCSV:
+		return NewHistoryCSVEncoder(w), nil
+	case HistoryFormatJSONL:
+		return NewHistoryJSONLEncoder(w), nil
+	case HistoryFormatICS:
+		return NewHistoryICSEncoder(w), nil
+	}
+	return nil, fmt.Errorf("unknown history format %q", format)
+}
+
+type historyCSVEncoder struct {
+	w      *csv.Writer
+	header bool
+}
+
+// NewHistoryCSVEncoder returns an encoder writing a header line followed by
+// one "at,type,name" line per entry.
+func NewHistoryCSVEncoder(w io.Writer) HistoryEncoder {
+	return &historyCSVEncoder{
+		w: csv.NewWriter(w),
+	}
+}
+
+func (e *historyCSVEncoder) writeHeader() error {
+	if e.header {
+		return nil
+	}
+	e.header = true
+	return e.w.Write([]string{"at", "type", "name"})
+}
+
+func (e *historyCSVEncoder) Encode(entry HistoryEntry) error {
+	if err := e.writeHeader(); err != nil {
+		return err
+	}
+	return e.w.Write([]string{entry.At.Format(time.RFC3339), entry.Type, entry.Name})
+}
+
+func (e *historyCSVEncoder) Close() error {
+	if err := e.writeHeader(); err != nil {
+		return err
+	}
+	e.w.Flush()
+	return e.w.Error()
+}
+
+type historyJSONLEncoder struct {
+	e *json.Encoder
+}
+
+// NewHistoryJSONLEncoder returns an encoder writing one JSON object per line.
+func NewHistoryJSONLEncoder(w io.Writer) HistoryEncoder {
+	e := json.NewEncoder(w)
+	e.SetEscapeHTML(false)
+	return &historyJSONLEncoder{
+		e: e,
+	}
+}
+
+func (e *historyJSONLEncoder) Encode(entry HistoryEntry) error {
+	return e.e.Encode(struct {
+		At   string `json:"at"`
+		Type string `json:"type"`
+		Name string `json:"name"`
+	}{
+		At:   entry.At.Format(time.RFC3339),
+		Type: entry.Type,
+		Name: entry.Name,
+	})
+}
+
+func (e *historyJSONLEncoder) Close() error {
+	return nil
+}
+
+type historyICSEncoder struct {
+	w      io.Writer
+	header bool
+	err    error
+}
+
+// NewHistoryICSEncoder returns an encoder writing an iCalendar file with
+// one event per entry.
+func NewHistoryICSEncoder(w io.Writer) HistoryEncoder {
+	return &historyICSEncoder{
+		w: w,
+	}
+}
+
+func (e *historyICSEncoder) line(s string) {
+	if e.err != nil {
+		return
+	}
+
+	// Lines longer than 75 octets are folded (RFC 5545, 3.1)
+	// without splitting a UTF-8 sequence.
+	var sb strings.Builder
+	n := 0
+	for _, r := range s {
+		size := len(string(r))
+		if n+size > 75 {
+			sb.WriteString("\r\n ")
+			n = 1
+		}
+		sb.WriteRune(r)
+		n += size
+	}
+	sb.WriteString("\r\n")
+
+	_, e.err = io.WriteString(e.w, sb.String())
+}
+
+func (e *historyICSEncoder) writeHeader() {
+	if e.header {
+		return
+	}
+	e.header = true
+	e.line("BEGIN:VCALENDAR")
+	e.line("VERSION:2.0")
+	e.line("PRODID:-//RyuaNerin//uotp//EN")
+	e.line("CALSCALE:GREGORIAN")
+}
+
+func icsEscape(s string) string {
+	return strings.NewReplacer(
+		`\`, `\\`,
+		";", `\;`,
+		",", `\,`,
+		"\n", `\n`,
+	).Replace(s)
+}
+
+func (e *historyICSEncoder) Encode(entry HistoryEntry) error {
+	e.writeHeader()
+
+	at := entry.At.UTC().Format("20060102T150405Z")
+
+	h := sha1.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s", entry.At.Format(time.RFC3339), entry.Type, entry.Name)
+
+	e.line("BEGIN:VEVENT")
+	e.line("UID:" + hex.EncodeToString(h.Sum(nil)) + "@uotp")
+	e.line("DTSTAMP:" + at)
+	e.line("DTSTART:" + at)
+	e.line("DTEND:" + at)
+	e.line("SUMMARY:" + icsEscape(entry.Type+" - "+entry.Name))
+	e.line("DESCRIPTION:" + icsEscape(entry.At.Format(time.RFC3339)+" "+entry.Type+" "+entry.Name))
+	e.line("END:VEVENT")
+
+	return e.err
+}
+
+func (e *historyICSEncoder) Close() error {
+	e.writeHeader()
+	e.line("END:VCALENDAR")
+	return e.err
+}
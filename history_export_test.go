@@ -0,0 +1,89 @@
+package uotp
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHistoryEncoders(t *testing.T) {
+	kst := time.FixedZone("KST", 9*60*60)
+	entries := []HistoryEntry{
+		{At: time.Date(2022, 3, 1, 12, 34, 56, 0, kst), Type: "인증", Name: "서비스, 1"},
+		{At: time.Date(2022, 3, 2, 1, 2, 3, 0, kst), Type: "오류 초기화", Name: "<OTP>"},
+	}
+
+	cases := []struct {
+		format HistoryFormat
+		want   string
+	}{
+		{
+			HistoryFormatCSV,
+			"at,type,name\n" +
+				"2022-03-01T12:34:56+09:00,인증,\"서비스, 1\"\n" +
+				"2022-03-02T01:02:03+09:00,오류 초기화,<OTP>\n",
+		},
+		{
+			HistoryFormatJSONL,
+			`{"at":"2022-03-01T12:34:56+09:00","type":"인증","name":"서비스, 1"}` + "\n" +
+				`{"at":"2022-03-02T01:02:03+09:00","type":"오류 초기화","name":"<OTP>"}` + "\n",
+		},
+	}
+
+	for _, c := range cases {
+		var buf bytes.Buffer
+		e, err := NewHistoryEncoder(&buf, c.format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, entry := range entries {
+			if err := e.Encode(entry); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := e.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		if buf.String() != c.want {
+			t.Errorf("%s:\n%s\nwant:\n%s", c.format, buf.String(), c.want)
+		}
+	}
+}
+
+func TestHistoryICSEncoder(t *testing.T) {
+	kst := time.FixedZone("KST", 9*60*60)
+
+	var buf bytes.Buffer
+	e := NewHistoryICSEncoder(&buf)
+	e.Encode(HistoryEntry{At: time.Date(2022, 3, 1, 12, 34, 56, 0, kst), Type: "인증", Name: "서비스; " + strings.Repeat("가", 30)})
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+
+	for _, want := range []string{
+		"BEGIN:VCALENDAR\r\n",
+		"BEGIN:VEVENT\r\n",
+		"DTSTART:20220301T033456Z\r\n",
+		`SUMMARY:인증 - 서비스\; `,
+		"END:VCALENDAR\r\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in\n%s", want, out)
+		}
+	}
+
+	for _, line := range strings.Split(out, "\r\n") {
+		if len(line) > 75 {
+			t.Errorf("line is not folded: %q", line)
+		}
+	}
+}
+
+func TestHistoryEncoderUnknownFormat(t *testing.T) {
+	if _, err := NewHistoryEncoder(&bytes.Buffer{}, "xml"); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
@@ -0,0 +1,357 @@
+package uotp
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// EntryKind is what a history entry records, parsed from its Type.
+type EntryKind int
+
+const (
+	EntryKindUnknown EntryKind = iota
+	// EntryKindAuth is a successful authentication with a token.
+	EntryKindAuth
+	// EntryKindFailure is a rejected token.
+	EntryKindFailure
+	// EntryKindResetError is a reset of the error count.
+	EntryKindResetError
+	// EntryKindIssue is the issue or reissue of the account.
+	EntryKindIssue
+	// EntryKindRevoke is the cancellation of the account.
+	EntryKindRevoke
+)
+
+func (k EntryKind) String() string {
+	switch k {
+	case EntryKindAuth:
+		return "auth"
+	case EntryKindFailure:
+		return "failure"
+	case EntryKindResetError:
+		return "reset_error"
+	case EntryKindIssue:
+		return "issue"
+	case EntryKindRevoke:
+		return "revoke"
+	}
+	return "unknown"
+}
+
+// MarshalText makes EntryKind readable in JSON.
+func (k EntryKind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// UnmarshalText parses the text returned by EntryKind.String.
+// Unknown text is EntryKindUnknown.
+func (k *EntryKind) UnmarshalText(text []byte) error {
+	for *k = EntryKindRevoke; *k > EntryKindUnknown; *k-- {
+		if k.String() == string(text) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// entryKindKeywords is checked in order, so "오류 초기화" is a reset and not
+// a failure.
+var entryKindKeywords = []struct {
+	keyword string
+	kind    EntryKind
+}{
+	{"초기화", EntryKindResetError},
+	{"실패", EntryKindFailure},
+	{"오류", EntryKindFailure},
+	{"불일치", EntryKindFailure},
+	{"발급", EntryKindIssue},
+	{"해지", EntryKindRevoke},
+	{"폐기", EntryKindRevoke},
+	{"인증", EntryKindAuth},
+	{"사용", EntryKindAuth},
+}
+
+// ParseEntryKind returns the kind of entry described by typ, the Korean text
+// the server sends, such as "인증" or "오류 초기화".
+func ParseEntryKind(typ string) EntryKind {
+	typ = strings.Join(strings.Fields(typ), " ")
+	for _, k := range entryKindKeywords {
+		if strings.Contains(typ, k.keyword) {
+			return k.kind
+		}
+	}
+	return EntryKindUnknown
+}
+
+// Kind returns the kind of the entry.
+func (e HistoryEntry) Kind() EntryKind {
+	return ParseEntryKind(e.Type)
+}
+
+// ServiceStats counts the entries of one service.
+type ServiceStats struct {
+	Name  string            `json:"name"`
+	Count int               `json:"count"`
+	Kinds map[EntryKind]int `json:"kinds"`
+	First time.Time         `json:"first"`
+	Last  time.Time         `json:"last"`
+}
+
+// HistoryStats summarizes a set of history entries.
+type HistoryStats struct {
+	Total int       `json:"total"`
+	From  time.Time `json:"from"`
+	To    time.Time `json:"to"`
+	// Services is sorted by count, most used first.
+	Services []ServiceStats    `json:"services"`
+	Kinds    map[EntryKind]int `json:"kinds"`
+	// ByHour counts entries by the hour of the day they happened at.
+	ByHour [24]int `json:"by_hour"`
+}
+
+// ComputeHistoryStats summarizes entries.
+func ComputeHistoryStats(entries []HistoryEntry) *HistoryStats {
+	s := &HistoryStats{
+		Kinds: make(map[EntryKind]int),
+	}
+
+	services := make(map[string]*ServiceStats)
+	for _, e := range entries {
+		s.Total++
+		if s.From.IsZero() || e.At.Before(s.From) {
+			s.From = e.At
+		}
+		if e.At.After(s.To) {
+			s.To = e.At
+		}
+
+		kind := e.Kind()
+		s.Kinds[kind]++
+		s.ByHour[e.At.Hour()]++
+
+		svc, ok := services[e.Name]
+		if !ok {
+			svc = &ServiceStats{
+				Name:  e.Name,
+				Kinds: make(map[EntryKind]int),
+				First: e.At,
+				Last:  e.At,
+			}
+			services[e.Name] = svc
+		}
+		svc.Count++
+		svc.Kinds[kind]++
+		if e.At.Before(svc.First) {
+			svc.First = e.At
+		}
+		if e.At.After(svc.Last) {
+			svc.Last = e.At
+		}
+	}
+
+	for _, svc := range services {
+		s.Services = append(s.Services, *svc)
+	}
+	sort.Slice(s.Services, func(i, j int) bool {
+		if s.Services[i].Count != s.Services[j].Count {
+			return s.Services[i].Count > s.Services[j].Count
+		}
+		return s.Services[i].Name < s.Services[j].Name
+	})
+
+	return s
+}
+
+// HistoryBaseline is what normal usage looks like, learned from past entries.
+type HistoryBaseline struct {
+	Total    int            `json:"total"`
+	From     time.Time      `json:"from"`
+	To       time.Time      `json:"to"`
+	Services map[string]int `json:"services"`
+	ByHour   [24]int        `json:"by_hour"`
+}
+
+// LearnHistoryBaseline returns the baseline of entries.
+func LearnHistoryBaseline(entries []HistoryEntry) *HistoryBaseline {
+	s := ComputeHistoryStats(entries)
+
+	b := &HistoryBaseline{
+		Total:    s.Total,
+		From:     s.From,
+		To:       s.To,
+		Services: make(map[string]int, len(s.Services)),
+		ByHour:   s.ByHour,
+	}
+	for _, svc := range s.Services {
+		b.Services[svc.Name] = svc.Count
+	}
+	return b
+}
+
+// HistoryRules configures which entries DetectAnomalies reports.
+// A zero field disables its rule.
+type HistoryRules struct {
+	// UnknownService reports services that are not in the baseline.
+	UnknownService bool
+
+	// QuietHoursStart and QuietHoursEnd are the hours of the day, from start
+	// included to end excluded, in which any activity is reported. The range
+	// may wrap around midnight.
+	QuietHoursStart int
+	QuietHoursEnd   int
+
+	// RareHourShare reports entries at an hour that holds less than this
+	// share of the baseline. It needs a baseline of at least MinBaseline
+	// entries.
+	RareHourShare float64
+	MinBaseline   int
+
+	// BurstCount authentications within BurstWindow are reported as a burst.
+	BurstCount  int
+	BurstWindow time.Duration
+
+	// MaxFailures failures within FailureWindow are reported.
+	MaxFailures   int
+	FailureWindow time.Duration
+}
+
+// DefaultHistoryRules returns the rules used by "uotp history report".
+func DefaultHistoryRules() HistoryRules {
+	return HistoryRules{
+		UnknownService:  true,
+		QuietHoursStart: 1,
+		QuietHoursEnd:   5,
+		RareHourShare:   0.01,
+		MinBaseline:     50,
+		BurstCount:      5,
+		BurstWindow:     10 * time.Minute,
+		MaxFailures:     3,
+		FailureWindow:   time.Hour,
+	}
+}
+
+func (r *HistoryRules) quietHour(hour int) bool {
+	if r.QuietHoursStart == r.QuietHoursEnd {
+		return false
+	}
+	if r.QuietHoursStart < r.QuietHoursEnd {
+		return r.QuietHoursStart <= hour && hour < r.QuietHoursEnd
+	}
+	return r.QuietHoursStart <= hour || hour < r.QuietHoursEnd
+}
+
+// AlertRule names the rule that raised an alert.
+type AlertRule string
+
+const (
+	AlertUnknownService AlertRule = "unknown_service"
+	AlertOddHour        AlertRule = "odd_hour"
+	AlertBurst          AlertRule = "burst"
+	AlertFailures       AlertRule = "failures"
+)
+
+// Alert is unusual activity found by DetectAnomalies.
+type Alert struct {
+	Rule    AlertRule      `json:"rule"`
+	At      time.Time      `json:"at"`
+	Message string         `json:"message"`
+	Entries []HistoryEntry `json:"entries"`
+}
+
+// DetectAnomalies checks entries against rules and, when it is not nil,
+// against baseline. Alerts are sorted by time.
+func DetectAnomalies(entries []HistoryEntry, baseline *HistoryBaseline, rules HistoryRules) []Alert {
+	sorted := make([]HistoryEntry, len(entries))
+	copy(sorted, entries)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].At.Before(sorted[j].At)
+	})
+
+	var alerts []Alert
+
+	if rules.UnknownService && baseline != nil {
+		reported := make(map[string]bool)
+		for _, e := range sorted {
+			if _, ok := baseline.Services[e.Name]; ok || reported[e.Name] {
+				continue
+			}
+			reported[e.Name] = true
+			alerts = append(alerts, Alert{
+				Rule:    AlertUnknownService,
+				At:      e.At,
+				Message: fmt.Sprintf("first use of %q", e.Name),
+				Entries: []HistoryEntry{e},
+			})
+		}
+	}
+
+	rareHours := baseline != nil && rules.RareHourShare > 0 && baseline.Total > 0 && baseline.Total >= rules.MinBaseline
+	for _, e := range sorted {
+		hour := e.At.Hour()
+		switch {
+		case rules.quietHour(hour):
+			alerts = append(alerts, Alert{
+				Rule:    AlertOddHour,
+				At:      e.At,
+				Message: fmt.Sprintf("activity at %02d:00, in quiet hours", hour),
+				Entries: []HistoryEntry{e},
+			})
+		case rareHours && float64(baseline.ByHour[hour]) < rules.RareHourShare*float64(baseline.Total):
+			alerts = append(alerts, Alert{
+				Rule:    AlertOddHour,
+				At:      e.At,
+				Message: fmt.Sprintf("activity at %02d:00, rarely used before", hour),
+				Entries: []HistoryEntry{e},
+			})
+		}
+	}
+
+	alerts = appendWindowAlerts(alerts, sorted, EntryKindAuth, rules.BurstCount, rules.BurstWindow, AlertBurst, "%d authentications within %s")
+	alerts = appendWindowAlerts(alerts, sorted, EntryKindFailure, rules.MaxFailures, rules.FailureWindow, AlertFailures, "%d failures within %s")
+
+	sort.SliceStable(alerts, func(i, j int) bool {
+		return alerts[i].At.Before(alerts[j].At)
+	})
+	return alerts
+}
+
+// appendWindowAlerts reports every run of at least count entries of kind
+// that fits in window. sorted must be sorted by time.
+func appendWindowAlerts(alerts []Alert, sorted []HistoryEntry, kind EntryKind, count int, window time.Duration, rule AlertRule, format string) []Alert {
+	if count <= 0 || window <= 0 {
+		return alerts
+	}
+
+	var matched []HistoryEntry
+	for _, e := range sorted {
+		if e.Kind() == kind {
+			matched = append(matched, e)
+		}
+	}
+
+	for start := 0; start+count <= len(matched); {
+		if matched[start+count-1].At.Sub(matched[start].At) > window {
+			start++
+			continue
+		}
+
+		// extend the run as long as it stays within the window
+		end := start + count
+		for end < len(matched) && matched[end].At.Sub(matched[start].At) <= window {
+			end++
+		}
+
+		run := matched[start:end:end]
+		alerts = append(alerts, Alert{
+			Rule:    rule,
+			At:      run[0].At,
+			Message: fmt.Sprintf(format, len(run), window),
+			Entries: run,
+		})
+		start = end
+	}
+
+	return alerts
+}
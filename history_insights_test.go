@@ -0,0 +1,177 @@
+package uotp
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseEntryKind(t *testing.T) {
+	tests := []struct {
+		typ  string
+		want EntryKind
+	}{
+		{"인증", EntryKindAuth},
+		{"OTP 인증", EntryKindAuth},
+		{"인증 실패", EntryKindFailure},
+		{"오류", EntryKindFailure},
+		{"오류 초기화", EntryKindResetError},
+		{"오류  초기화", EntryKindResetError},
+		{"발급", EntryKindIssue},
+		{"재발급", EntryKindIssue},
+		{"해지", EntryKindRevoke},
+		{"", EntryKindUnknown},
+		{"기타", EntryKindUnknown},
+	}
+	for _, tt := range tests {
+		if got := ParseEntryKind(tt.typ); got != tt.want {
+			t.Errorf("ParseEntryKind(%q) = %v, want %v", tt.typ, got, tt.want)
+		}
+	}
+}
+
+func TestComputeHistoryStats(t *testing.T) {
+	base := time.Date(2022, 3, 1, 9, 0, 0, 0, serverLocation)
+	entries := []HistoryEntry{
+		{At: base, Type: "인증", Name: "A"},
+		{At: base.Add(time.Hour), Type: "인증", Name: "B"},
+		{At: base.Add(2 * time.Hour), Type: "인증 실패", Name: "A"},
+	}
+
+	s := ComputeHistoryStats(entries)
+	if s.Total != 3 || !s.From.Equal(base) || !s.To.Equal(base.Add(2*time.Hour)) {
+		t.Errorf("got total %d from %v to %v", s.Total, s.From, s.To)
+	}
+	if len(s.Services) != 2 || s.Services[0].Name != "A" || s.Services[0].Count != 2 {
+		t.Errorf("services = %+v", s.Services)
+	}
+	if s.Services[0].Kinds[EntryKindFailure] != 1 {
+		t.Errorf("kinds of A = %v", s.Services[0].Kinds)
+	}
+	if s.ByHour[9] != 1 || s.ByHour[10] != 1 || s.ByHour[11] != 1 {
+		t.Errorf("by hour = %v", s.ByHour)
+	}
+	if s.Kinds[EntryKindAuth] != 2 {
+		t.Errorf("kinds = %v", s.Kinds)
+	}
+}
+
+func TestHistoryStatsJSON(t *testing.T) {
+	base := time.Date(2022, 3, 1, 9, 0, 0, 0, serverLocation)
+	s := ComputeHistoryStats([]HistoryEntry{
+		{At: base, Type: "인증", Name: "A"},
+		{At: base.Add(time.Hour), Type: "인증 실패", Name: "A"},
+	})
+
+	data, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"kinds":{"auth":1,"failure":1}`) {
+		t.Errorf("kinds missing from %s", data)
+	}
+
+	var got HistoryStats
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.Kinds, s.Kinds) || !reflect.DeepEqual(got.Services[0].Kinds, s.Services[0].Kinds) {
+		t.Errorf("decoded kinds %v and %v, want %v and %v", got.Kinds, got.Services[0].Kinds, s.Kinds, s.Services[0].Kinds)
+	}
+}
+
+func alertRules(alerts []Alert) []AlertRule {
+	var r []AlertRule
+	for _, a := range alerts {
+		r = append(r, a.Rule)
+	}
+	return r
+}
+
+func TestDetectAnomalies(t *testing.T) {
+	day := time.Date(2022, 3, 1, 0, 0, 0, 0, serverLocation)
+
+	var past []HistoryEntry
+	for i := 0; i < 60; i++ {
+		past = append(past, HistoryEntry{At: day.AddDate(0, 0, -i).Add(10 * time.Hour), Type: "인증", Name: "Bank"})
+	}
+	baseline := LearnHistoryBaseline(past)
+
+	rules := DefaultHistoryRules()
+
+	t.Run("normal", func(t *testing.T) {
+		entries := []HistoryEntry{
+			{At: day.Add(10 * time.Hour), Type: "인증", Name: "Bank"},
+		}
+		if alerts := DetectAnomalies(entries, baseline, rules); len(alerts) != 0 {
+			t.Errorf("got %v", alertRules(alerts))
+		}
+	})
+
+	t.Run("unknown service", func(t *testing.T) {
+		entries := []HistoryEntry{
+			{At: day.Add(10 * time.Hour), Type: "인증", Name: "Shop"},
+			{At: day.Add(10*time.Hour + time.Minute*30), Type: "인증", Name: "Shop"},
+		}
+		alerts := DetectAnomalies(entries, baseline, rules)
+		if len(alerts) != 1 || alerts[0].Rule != AlertUnknownService {
+			t.Errorf("got %v", alertRules(alerts))
+		}
+	})
+
+	t.Run("quiet hours", func(t *testing.T) {
+		entries := []HistoryEntry{
+			{At: day.Add(3 * time.Hour), Type: "인증", Name: "Bank"},
+		}
+		alerts := DetectAnomalies(entries, nil, rules)
+		if len(alerts) != 1 || alerts[0].Rule != AlertOddHour {
+			t.Errorf("got %v", alertRules(alerts))
+		}
+	})
+
+	t.Run("rare hour", func(t *testing.T) {
+		entries := []HistoryEntry{
+			{At: day.Add(20 * time.Hour), Type: "인증", Name: "Bank"},
+		}
+		if alerts := DetectAnomalies(entries, nil, rules); len(alerts) != 0 {
+			t.Errorf("without baseline got %v", alertRules(alerts))
+		}
+		alerts := DetectAnomalies(entries, baseline, rules)
+		if len(alerts) != 1 || alerts[0].Rule != AlertOddHour {
+			t.Errorf("got %v", alertRules(alerts))
+		}
+	})
+
+	t.Run("burst", func(t *testing.T) {
+		var entries []HistoryEntry
+		for i := 0; i < 6; i++ {
+			entries = append(entries, HistoryEntry{At: day.Add(10*time.Hour + time.Duration(i)*time.Minute), Type: "인증", Name: "Bank"})
+		}
+		entries = append(entries, HistoryEntry{At: day.Add(10*time.Hour + 30*time.Minute), Type: "인증", Name: "Bank"})
+
+		alerts := DetectAnomalies(entries, baseline, rules)
+		if len(alerts) != 1 || alerts[0].Rule != AlertBurst || len(alerts[0].Entries) != 6 {
+			t.Errorf("got %v", alerts)
+		}
+	})
+
+	t.Run("failures", func(t *testing.T) {
+		var entries []HistoryEntry
+		for i := 0; i < 3; i++ {
+			entries = append(entries, HistoryEntry{At: day.Add(10*time.Hour + time.Duration(i)*20*time.Minute), Type: "인증 실패", Name: "Bank"})
+		}
+
+		alerts := DetectAnomalies(entries, baseline, rules)
+		if len(alerts) != 1 || alerts[0].Rule != AlertFailures {
+			t.Errorf("got %v", alertRules(alerts))
+		}
+
+		rules := rules
+		rules.MaxFailures = 0
+		if alerts := DetectAnomalies(entries, baseline, rules); len(alerts) != 0 {
+			t.Errorf("disabled rule got %v", alertRules(alerts))
+		}
+	})
+}
@@ -0,0 +1,392 @@
+package uotp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/RyuaNerin/uotp/internal/fileutil"
+)
+
+// HistoryEvent is a new history entry delivered to a HistorySink.
+type HistoryEvent struct {
+	SerialNumber string    `json:"serial_number"`
+	At           time.Time `json:"at"`
+	Type         string    `json:"type"`
+	Name         string    `json:"name"`
+	Kind         EntryKind `json:"kind"`
+}
+
+// HistorySink receives the new entries found by a HistoryMonitor.
+type HistorySink interface {
+	Deliver(ctx context.Context, event *HistoryEvent) error
+}
+
+// HistorySinkFunc adapts a function to HistorySink.
+type HistorySinkFunc func(ctx context.Context, event *HistoryEvent) error
+
+func (f HistorySinkFunc) Deliver(ctx context.Context, event *HistoryEvent) error {
+	return f(ctx, event)
+}
+
+type writerSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewWriterSink returns a sink writing each event to w as a line of JSON.
+func NewWriterSink(w io.Writer) HistorySink {
+	return &writerSink{w: w}
+}
+
+func (s *writerSink) Deliver(ctx context.Context, event *HistoryEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.w.Write(append(data, '\n'))
+	return err
+}
+
+type webhookSink struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhookSink returns a sink posting each event as JSON to url.
+// Any status other than 2xx is an error. http.DefaultClient is used if
+// client is nil.
+func NewWebhookSink(url string, client *http.Client) HistorySink {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &webhookSink{
+		url:    url,
+		client: client,
+	}
+}
+
+func (s *webhookSink) Deliver(ctx context.Context, event *HistoryEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook %s: %s", s.url, resp.Status)
+	}
+	return nil
+}
+
+type commandSink struct {
+	name string
+	args []string
+}
+
+// NewCommandSink returns a sink running a command for each event, with the
+// event as JSON on its standard input. A non-zero exit status is an error.
+func NewCommandSink(name string, args ...string) HistorySink {
+	return &commandSink{
+		name: name,
+		args: args,
+	}
+}
+
+func (s *commandSink) Deliver(ctx context.Context, event *HistoryEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	var stderr bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, s.name, s.args...)
+	cmd.Stdin = bytes.NewReader(append(data, '\n'))
+	cmd.Stderr = &stderr
+
+	err = cmd.Run()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("%s: %w: %s", s.name, err, msg)
+		}
+		return fmt.Errorf("%s: %w", s.name, err)
+	}
+	return nil
+}
+
+// historyCursor is the newest entry a monitor has seen. Entries sharing its
+// time are all kept, since the server only has a resolution of a second.
+type historyCursor struct {
+	At      time.Time      `json:"at"`
+	Entries []HistoryEntry `json:"entries"`
+}
+
+func (c *historyCursor) isNew(e HistoryEntry) bool {
+	if c.At.IsZero() || e.At.After(c.At) {
+		return true
+	}
+	if e.At.Before(c.At) {
+		return false
+	}
+	for _, seen := range c.Entries {
+		if seen.Type == e.Type && seen.Name == e.Name {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *historyCursor) advance(e HistoryEntry) {
+	if e.At.After(c.At) {
+		c.At = e.At
+		c.Entries = c.Entries[:0]
+	}
+	c.Entries = append(c.Entries, e)
+}
+
+// HistoryMonitor polls the history of an account and delivers the entries
+// that were not seen before to its sinks.
+type HistoryMonitor struct {
+	OTP   UOTP
+	Sinks []HistorySink
+
+	// Interval is the time between polls. It defaults to a minute.
+	Interval time.Duration
+	// Period is the history period requested. It defaults to a week.
+	Period HistoryPeriod
+
+	// CursorPath is where the newest seen entry is kept, so a restarted
+	// monitor does not deliver entries again. The cursor is only kept in
+	// memory if it is empty.
+	CursorPath string
+	// NotifyExisting delivers the entries already on the server when there
+	// is no cursor yet. Otherwise they only set the cursor.
+	NotifyExisting bool
+
+	// Retries is how many times a failed delivery is tried again, waiting
+	// RetryDelay, then twice as long each time. If a sink still fails, the
+	// cursor stays before the entry, and it is delivered again to every sink
+	// at the next poll with the entries after it.
+	Retries    int
+	RetryDelay time.Duration
+
+	// SyncInterval, if not zero, synchronizes the time before a poll when
+	// the last synchronization is older.
+	SyncInterval time.Duration
+
+	// OnError is called with errors that do not stop the monitor, such as
+	// failed polls and deliveries. They are dropped if it is nil.
+	OnError func(err error)
+
+	cursor   *historyCursor
+	lastSync time.Time
+}
+
+// Run polls until ctx is done and returns ctx.Err(). It only returns earlier
+// when the cursor cannot be loaded.
+func (m *HistoryMonitor) Run(ctx context.Context) error {
+	if err := m.loadCursor(); err != nil {
+		return err
+	}
+
+	interval := m.Interval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := m.Poll(ctx); err != nil && ctx.Err() == nil {
+			m.reportError(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Poll fetches the history once and delivers the new entries, oldest first.
+// It returns how many entries were delivered. It stops at the first entry a
+// sink fails to take, and returns the error.
+func (m *HistoryMonitor) Poll(ctx context.Context) (int, error) {
+	if err := m.loadCursor(); err != nil {
+		return 0, err
+	}
+
+	if m.SyncInterval > 0 && time.Since(m.lastSync) >= m.SyncInterval {
+		if _, err := m.OTP.SyncTime(ctx); err != nil {
+			return 0, fmt.Errorf("failed to synchronize time: %w", err)
+		}
+		m.lastSync = time.Now()
+	}
+
+	period := m.Period
+	if period == 0 {
+		period = HistoryPeriodOneWeek
+	}
+
+	var fresh []HistoryEntry
+	for page := 1; ; page++ {
+		h, err := m.OTP.GetHistoryPeriod(ctx, page, period)
+		if err != nil {
+			return 0, err
+		}
+
+		seen := false
+		for _, e := range h.Entries {
+			if m.cursor.isNew(e) {
+				fresh = append(fresh, e)
+			} else {
+				seen = true
+			}
+		}
+
+		if seen || len(h.Entries) == 0 || page >= h.PageTotal {
+			break
+		}
+	}
+	sort.SliceStable(fresh, func(i, j int) bool {
+		return fresh[i].At.Before(fresh[j].At)
+	})
+
+	if m.cursor.At.IsZero() && !m.NotifyExisting {
+		for _, e := range fresh {
+			m.cursor.advance(e)
+		}
+		// An empty history still counts as seen, so later entries are delivered.
+		if m.cursor.At.IsZero() {
+			m.cursor.At = time.Unix(0, 0)
+		}
+		return 0, m.saveCursor()
+	}
+
+	for i, e := range fresh {
+		if err := m.deliver(ctx, e); err != nil {
+			// The entry may not have reached every sink; deliver it again
+			// next time.
+			return i, err
+		}
+
+		m.cursor.advance(e)
+		if err := m.saveCursor(); err != nil {
+			return i, err
+		}
+	}
+
+	return len(fresh), nil
+}
+
+// deliver gives the entry to every sink, and returns the first error of a
+// sink that failed after all retries.
+func (m *HistoryMonitor) deliver(ctx context.Context, e HistoryEntry) error {
+	event := &HistoryEvent{
+		SerialNumber: m.OTP.GetSerialNumber(),
+		At:           e.At,
+		Type:         e.Type,
+		Name:         e.Name,
+		Kind:         e.Kind(),
+	}
+
+	var failed error
+	for _, sink := range m.Sinks {
+		delay := m.RetryDelay
+		for attempt := 0; ; attempt++ {
+			err := sink.Deliver(ctx, event)
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if err == nil {
+				break
+			}
+			if attempt >= m.Retries {
+				if failed == nil {
+					failed = fmt.Errorf("failed to deliver the entry at %s: %w", e.At.Format(time.RFC3339), err)
+				}
+				break
+			}
+
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(delay):
+			}
+			delay *= 2
+		}
+	}
+	return failed
+}
+
+func (m *HistoryMonitor) reportError(err error) {
+	if m.OnError != nil {
+		m.OnError(err)
+	}
+}
+
+func (m *HistoryMonitor) loadCursor() error {
+	if m.cursor != nil {
+		return nil
+	}
+
+	m.cursor = &historyCursor{}
+	if m.CursorPath == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(m.CursorPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	err = json.Unmarshal(data, m.cursor)
+	if err != nil {
+		return fmt.Errorf("%s: %w", m.CursorPath, err)
+	}
+	return nil
+}
+
+// saveCursor writes the cursor to CursorPath, if set.
+func (m *HistoryMonitor) saveCursor() error {
+	if m.CursorPath == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(m.cursor)
+	if err != nil {
+		return err
+	}
+	return fileutil.WriteFile(m.CursorPath, data, 0600)
+}
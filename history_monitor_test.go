@@ -0,0 +1,241 @@
+package uotp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type recordingSink struct {
+	events []HistoryEvent
+}
+
+func (s *recordingSink) Deliver(ctx context.Context, event *HistoryEvent) error {
+	s.events = append(s.events, *event)
+	return nil
+}
+
+func TestHistoryMonitorPoll(t *testing.T) {
+	cursorPath := filepath.Join(t.TempDir(), "cursor.json")
+	entries := testHistoryEntries(15)
+
+	// The server has entries[10:] at first, then entries[5:].
+	f := &fakeServer{t: t, history: entries[10:], perPage: 3}
+	u := newTestUOTP(t, f)
+
+	sink := &recordingSink{}
+	m := &HistoryMonitor{
+		OTP:        u,
+		Sinks:      []HistorySink{sink},
+		CursorPath: cursorPath,
+	}
+
+	n, err := m.Poll(context.Background())
+	if err != nil || n != 0 || len(sink.events) != 0 {
+		t.Fatalf("first poll = %d, %v, delivered %d", n, err, len(sink.events))
+	}
+
+	f.history = entries[5:]
+	n, err = m.Poll(context.Background())
+	if err != nil || n != 5 {
+		t.Fatalf("second poll = %d, %v", n, err)
+	}
+	for i, event := range sink.events {
+		want := entries[9-i]
+		if !event.At.Equal(want.At) || event.Name != want.Name || event.Kind != EntryKindAuth || event.SerialNumber != testAccount.SerialNumber {
+			t.Errorf("event %d = %+v, want %+v", i, event, want)
+		}
+	}
+
+	// A restarted monitor resumes from the cursor.
+	f.history = entries
+	sink = &recordingSink{}
+	m = &HistoryMonitor{
+		OTP:        u,
+		Sinks:      []HistorySink{sink},
+		CursorPath: cursorPath,
+	}
+	n, err = m.Poll(context.Background())
+	if err != nil || n != 5 {
+		t.Fatalf("poll after restart = %d, %v", n, err)
+	}
+	if !sink.events[0].At.Equal(entries[4].At) {
+		t.Errorf("first event after restart at %v, want %v", sink.events[0].At, entries[4].At)
+	}
+}
+
+func TestHistoryMonitorNotifyExisting(t *testing.T) {
+	u := newTestUOTP(t, &fakeServer{t: t, history: testHistoryEntries(4)})
+
+	sink := &recordingSink{}
+	m := &HistoryMonitor{
+		OTP:            u,
+		Sinks:          []HistorySink{sink},
+		NotifyExisting: true,
+	}
+	n, err := m.Poll(context.Background())
+	if err != nil || n != 4 || len(sink.events) != 4 {
+		t.Fatalf("poll = %d, %v, delivered %d", n, err, len(sink.events))
+	}
+
+	n, err = m.Poll(context.Background())
+	if err != nil || n != 0 {
+		t.Errorf("second poll = %d, %v", n, err)
+	}
+}
+
+func TestHistoryMonitorRetry(t *testing.T) {
+	u := newTestUOTP(t, &fakeServer{t: t, history: testHistoryEntries(1)})
+
+	calls := 0
+	flaky := HistorySinkFunc(func(ctx context.Context, event *HistoryEvent) error {
+		calls++
+		if calls < 3 {
+			return errors.New("unavailable")
+		}
+		return nil
+	})
+	broken := HistorySinkFunc(func(ctx context.Context, event *HistoryEvent) error {
+		return errors.New("broken")
+	})
+
+	m := &HistoryMonitor{
+		OTP:            u,
+		Sinks:          []HistorySink{flaky, broken},
+		NotifyExisting: true,
+		Retries:        2,
+		RetryDelay:     time.Millisecond,
+	}
+	if n, err := m.Poll(context.Background()); n != 0 || err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Errorf("poll = %d, %v", n, err)
+	}
+	if calls != 3 {
+		t.Errorf("flaky sink called %d times, want 3", calls)
+	}
+}
+
+func TestHistoryMonitorRedeliver(t *testing.T) {
+	entries := testHistoryEntries(3)
+	u := newTestUOTP(t, &fakeServer{t: t, history: entries})
+
+	down := true
+	sink := &recordingSink{}
+	m := &HistoryMonitor{
+		OTP: u,
+		Sinks: []HistorySink{HistorySinkFunc(func(ctx context.Context, event *HistoryEvent) error {
+			if down {
+				return errors.New("unavailable")
+			}
+			return sink.Deliver(ctx, event)
+		})},
+		CursorPath:     filepath.Join(t.TempDir(), "cursor.json"),
+		NotifyExisting: true,
+		Retries:        2,
+		RetryDelay:     time.Millisecond,
+	}
+
+	// The sink fails for longer than the retries, over two polls.
+	for i := 0; i < 2; i++ {
+		if n, err := m.Poll(context.Background()); n != 0 || err == nil {
+			t.Fatalf("poll %d while the sink is down = %d, %v", i, n, err)
+		}
+	}
+
+	down = false
+	n, err := m.Poll(context.Background())
+	if err != nil || n != len(entries) {
+		t.Fatalf("poll after the sink is back = %d, %v", n, err)
+	}
+	for i, event := range sink.events {
+		if want := entries[len(entries)-1-i]; !event.At.Equal(want.At) || event.Name != want.Name {
+			t.Errorf("event %d = %+v, want %+v", i, event, want)
+		}
+	}
+
+	if n, err = m.Poll(context.Background()); err != nil || n != 0 {
+		t.Errorf("poll after delivery = %d, %v", n, err)
+	}
+}
+
+func TestWebhookSink(t *testing.T) {
+	var got HistoryEvent
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("content type %q", r.Header.Get("Content-Type"))
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Error(err)
+		}
+		if got.Name == "fail" {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer srv.Close()
+
+	sink := NewWebhookSink(srv.URL, srv.Client())
+	event := &HistoryEvent{At: time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC), Type: "인증", Name: "Bank", Kind: EntryKindAuth}
+	if err := sink.Deliver(context.Background(), event); err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "Bank" || got.Kind != EntryKindAuth {
+		t.Errorf("got %+v", got)
+	}
+
+	event.Name = "fail"
+	if err := sink.Deliver(context.Background(), event); err == nil {
+		t.Error("expected an error for status 502")
+	}
+}
+
+func TestWriterSink(t *testing.T) {
+	var buf bytes.Buffer
+	sink := NewWriterSink(&buf)
+	event := &HistoryEvent{At: time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC), Type: "오류 초기화", Name: "OTP", Kind: EntryKindResetError}
+	if err := sink.Deliver(context.Background(), event); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{"serial_number":"","at":"2022-03-01T00:00:00Z","type":"오류 초기화","name":"OTP","kind":"reset_error"}` + "\n"
+	if buf.String() != want {
+		t.Errorf("got %s, want %s", buf.String(), want)
+	}
+}
+
+func TestCommandSink(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh not found")
+	}
+
+	out := filepath.Join(t.TempDir(), "out")
+	sink := NewCommandSink(sh, "-c", `cat > "$0"`, out)
+	event := &HistoryEvent{Name: "Bank"}
+	if err := sink.Deliver(context.Background(), event); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	data, _ := io.ReadAll(f)
+	if !strings.Contains(string(data), `"name":"Bank"`) {
+		t.Errorf("stdin was %s", data)
+	}
+
+	sink = NewCommandSink(sh, "-c", "echo oops >&2; exit 1")
+	if err := sink.Deliver(context.Background(), event); err == nil || !strings.Contains(err.Error(), "oops") {
+		t.Errorf("got %v", err)
+	}
+}
@@ -0,0 +1,101 @@
+package uotp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func testHistoryEntries(n int) []HistoryEntry {
+	entries := make([]HistoryEntry, n)
+	for i := range entries {
+		entries[i] = HistoryEntry{
+			At:   time.Date(2022, 3, 1, 0, 0, 0, 0, serverLocation).Add(time.Duration(-i) * time.Hour),
+			Type: "인증",
+			Name: fmt.Sprintf("서비스%d", i),
+		}
+	}
+	return entries
+}
+
+func TestHistoryAll(t *testing.T) {
+	entries := testHistoryEntries(25)
+
+	var pages []int
+	u := newTestUOTP(t, &fakeServer{t: t, history: entries, onHistory: func(page int, period HistoryPeriod) {
+		if period != HistoryPeriodOneMonth {
+			t.Errorf("requested period %d", period)
+		}
+		pages = append(pages, page)
+	}})
+
+	var got []HistoryEntry
+	it := u.HistoryAll(context.Background(), HistoryPeriodOneMonth)
+	for it.Next() {
+		got = append(got, it.Entry())
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(got, entries) {
+		t.Errorf("got %d entries, want %d", len(got), len(entries))
+	}
+	if !reflect.DeepEqual(pages, []int{1, 2, 3}) {
+		t.Errorf("requested pages %v", pages)
+	}
+	if it.PeriodStart.IsZero() || it.PeriodEnd.IsZero() {
+		t.Error("period is not set")
+	}
+}
+
+func TestHistoryAllEmpty(t *testing.T) {
+	u := newTestUOTP(t, &fakeServer{t: t})
+
+	it := u.HistoryAll(context.Background(), HistoryPeriodOneWeek)
+	if it.Next() {
+		t.Error("expected no entries")
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHistoryAllCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var pages []int
+	u := newTestUOTP(t, &fakeServer{t: t, history: testHistoryEntries(25), onHistory: func(page int, period HistoryPeriod) {
+		pages = append(pages, page)
+	}})
+
+	it := u.HistoryAll(ctx, HistoryPeriodThreeMonths)
+	n := 0
+	for it.Next() {
+		n++
+		if n == 5 {
+			cancel()
+		}
+	}
+	if !errors.Is(it.Err(), context.Canceled) {
+		t.Errorf("got %v, want context.Canceled", it.Err())
+	}
+	if n != 5 || len(pages) != 1 {
+		t.Errorf("read %d entries from %d pages after cancel", n, len(pages))
+	}
+}
+
+func TestGetHistoryPeriodInvalid(t *testing.T) {
+	u := newTestUOTP(t, &fakeServer{t: t})
+
+	if _, err := u.GetHistoryPeriod(context.Background(), 1, 0); err != ErrInvalidPeriod {
+		t.Errorf("got %v, want ErrInvalidPeriod", err)
+	}
+	if _, err := u.GetHistoryPeriod(context.Background(), 0, HistoryPeriodOneWeek); err != ErrInvalidPage {
+		t.Errorf("got %v, want ErrInvalidPage", err)
+	}
+}
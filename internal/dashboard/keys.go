@@ -0,0 +1,104 @@
+package dashboard
+
+import "unicode/utf8"
+
+// Special is a key that is not a character.
+type Special int
+
+const (
+	KeyRune Special = iota
+	KeyUp
+	KeyDown
+	KeyLeft
+	KeyRight
+	KeyPageUp
+	KeyPageDown
+	KeyTab
+	KeyEnter
+	KeyEscape
+	KeyBackspace
+	KeyCtrlC
+)
+
+// Key is a key press: a character, or a special key.
+type Key struct {
+	Special Special
+	Rune    rune
+}
+
+// Rune returns the key of the character r.
+func Rune(r rune) Key {
+	return Key{Rune: r}
+}
+
+// escapes are the sequences terminals send for special keys.
+var escapes = []struct {
+	seq string
+	key Special
+}{
+	{"\x1b[A", KeyUp},
+	{"\x1b[B", KeyDown},
+	{"\x1b[C", KeyRight},
+	{"\x1b[D", KeyLeft},
+	{"\x1bOA", KeyUp},
+	{"\x1bOB", KeyDown},
+	{"\x1bOC", KeyRight},
+	{"\x1bOD", KeyLeft},
+	{"\x1b[5~", KeyPageUp},
+	{"\x1b[6~", KeyPageDown},
+}
+
+// ParseKeys decodes the keys in b, read from a terminal in raw mode.
+// Unknown escape sequences are dropped.
+func ParseKeys(b []byte) []Key {
+	var keys []Key
+	for len(b) > 0 {
+		switch b[0] {
+		case '\x1b':
+			n, key := parseEscape(b)
+			if key != KeyRune {
+				keys = append(keys, Key{Special: key})
+			}
+			b = b[n:]
+			continue
+		case '\t':
+			keys = append(keys, Key{Special: KeyTab})
+		case '\r', '\n':
+			keys = append(keys, Key{Special: KeyEnter})
+		case 0x7f, '\b':
+			keys = append(keys, Key{Special: KeyBackspace})
+		case 0x03:
+			keys = append(keys, Key{Special: KeyCtrlC})
+		default:
+			r, n := utf8.DecodeRune(b)
+			if r >= ' ' {
+				keys = append(keys, Rune(r))
+			}
+			b = b[n:]
+			continue
+		}
+		b = b[1:]
+	}
+	return keys
+}
+
+// parseEscape returns the length of the escape sequence at the start of b
+// and its key, KeyRune if it is unknown.
+func parseEscape(b []byte) (int, Special) {
+	for _, e := range escapes {
+		if len(b) >= len(e.seq) && string(b[:len(e.seq)]) == e.seq {
+			return len(e.seq), e.key
+		}
+	}
+	if len(b) == 1 || b[1] != '[' && b[1] != 'O' {
+		return 1, KeyEscape
+	}
+
+	// Skip an unknown CSI sequence up to its final byte.
+	for i := 2; i < len(b); i++ {
+		if b[i] >= 0x40 && b[i] <= 0x7e {
+			return i + 1, KeyRune
+		}
+	}
+	return len(b), KeyRune
+}
@@ -0,0 +1,369 @@
+// Package dashboard is the model of the full-screen terminal UI of the
+// CLI: its state, how keys and results change it, and how it is drawn as
+// lines of text.
+//
+// It does no I/O. Update returns the actions it starts, such as fetching a
+// page of history, as a Cmd for the caller to run; their results come back
+// to Update as messages.
+package dashboard
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/RyuaNerin/uotp"
+)
+
+// Account is an account shown on the dashboard.
+type Account struct {
+	Label string
+	OTP   uotp.UOTP
+}
+
+// Pane is a part of the dashboard that has the focus.
+type Pane int
+
+const (
+	PaneAccounts Pane = iota
+	PaneHistory
+)
+
+// Msg is a key press, a tick of the clock, or the result of a Cmd.
+type Msg interface{}
+
+type (
+	// KeyMsg is a key press.
+	KeyMsg Key
+	// TickMsg refreshes the tokens at the time it holds.
+	TickMsg time.Time
+	// ResizeMsg is the size of the terminal.
+	ResizeMsg struct {
+		Width, Height int
+	}
+
+	// SyncMsg is the result of a time synchronization.
+	SyncMsg struct {
+		Result *uotp.SyncResult
+		Err    error
+	}
+	// HistoryMsg is a page of history.
+	HistoryMsg struct {
+		Label   string
+		Page    int
+		History *uotp.History
+		Err     error
+	}
+	// ResetMsg is the result of resetting the error count.
+	ResetMsg struct {
+		Label string
+		Err   error
+	}
+	// InfoMsg is what the server knows about an account.
+	InfoMsg struct {
+		Label string
+		Info  *uotp.Information
+		Err   error
+	}
+)
+
+// Cmd runs an action started by Update and returns its result.
+type Cmd func(ctx context.Context) Msg
+
+// historyState is the history pane.
+type historyState struct {
+	label   string
+	page    int
+	history *uotp.History
+	loading bool
+	err     error
+	scroll  int
+}
+
+// Model is the state of the dashboard.
+type Model struct {
+	Accounts []Account
+	// Sync synchronizes the time of every account.
+	Sync func(ctx context.Context) (*uotp.SyncResult, error)
+
+	Width, Height int
+	Now           time.Time
+
+	Selected int
+	Focus    Pane
+	// Quit is set when the user asked to quit.
+	Quit bool
+	// ShowHelp shows the key bindings instead of the history.
+	ShowHelp bool
+	// Status is the message at the bottom.
+	Status string
+
+	tokens   []uotp.Token
+	history  historyState
+	info     map[string]*uotp.Information
+	lastSync *uotp.SyncResult
+	// confirm is the label of the account whose error count is reset
+	// when the user answers yes.
+	confirm string
+	pending int
+}
+
+// New returns the model of a dashboard showing accounts.
+func New(accounts []Account, sync func(ctx context.Context) (*uotp.SyncResult, error)) *Model {
+	m := &Model{
+		Accounts: accounts,
+		Sync:     sync,
+		Width:    80,
+		Height:   24,
+		info:     make(map[string]*uotp.Information),
+	}
+	m.refresh(time.Now())
+	return m
+}
+
+// Init returns the actions to run when the dashboard starts: a time
+// synchronization, and the first page of history of the first account.
+func (m *Model) Init() []Cmd {
+	var cmds []Cmd
+	if cmd := m.startSync(); cmd != nil {
+		cmds = append(cmds, cmd)
+	}
+	if cmd := m.loadHistory(1); cmd != nil {
+		cmds = append(cmds, cmd)
+	}
+	return cmds
+}
+
+// Busy reports whether actions are running.
+func (m *Model) Busy() bool {
+	return m.pending > 0
+}
+
+func (m *Model) selected() *Account {
+	if m.Selected < 0 || m.Selected >= len(m.Accounts) {
+		return nil
+	}
+	return &m.Accounts[m.Selected]
+}
+
+func (m *Model) refresh(now time.Time) {
+	m.Now = now
+	m.tokens = make([]uotp.Token, len(m.Accounts))
+	for i, a := range m.Accounts {
+		m.tokens[i] = a.OTP.Token()
+	}
+}
+
+// Update applies msg and returns the action it starts, if any.
+func (m *Model) Update(msg Msg) Cmd {
+	switch msg := msg.(type) {
+	case KeyMsg:
+		return m.key(Key(msg))
+
+	case TickMsg:
+		m.refresh(time.Time(msg))
+
+	case ResizeMsg:
+		m.Width, m.Height = msg.Width, msg.Height
+
+	case SyncMsg:
+		m.pending--
+		if msg.Err != nil {
+			m.Status = fmt.Sprintf("Synchronization failed: %v", msg.Err)
+			break
+		}
+		m.lastSync = msg.Result
+		m.Status = fmt.Sprintf("Synchronized with %s: %s ± %s", msg.Result.Source,
+			msg.Result.Offset.Round(time.Millisecond), msg.Result.Uncertainty.Round(time.Millisecond))
+		m.refresh(m.Now)
+
+	case HistoryMsg:
+		m.pending--
+		if msg.Label != m.history.label || msg.Page != m.history.page {
+			// The user moved on before the page arrived.
+			break
+		}
+		m.history.loading = false
+		m.history.err = msg.Err
+		if msg.Err == nil {
+			m.history.history = msg.History
+			m.history.scroll = 0
+		}
+
+	case ResetMsg:
+		m.pending--
+		if msg.Err != nil {
+			m.Status = fmt.Sprintf("%s: failed to reset the error count: %v", msg.Label, msg.Err)
+		} else {
+			m.Status = fmt.Sprintf("%s: the error count has been reset", msg.Label)
+		}
+
+	case InfoMsg:
+		m.pending--
+		if msg.Err != nil {
+			m.Status = fmt.Sprintf("%s: failed to get information: %v", msg.Label, msg.Err)
+			break
+		}
+		m.info[msg.Label] = msg.Info
+		m.Status = fmt.Sprintf("%s: OID %d, partner %s", msg.Label, msg.Info.OID, msg.Info.Partner)
+	}
+	return nil
+}
+
+func (m *Model) key(k Key) Cmd {
+	if k.Special == KeyCtrlC {
+		m.Quit = true
+		return nil
+	}
+
+	if m.confirm != "" {
+		label := m.confirm
+		m.confirm = ""
+		if k.Special == KeyRune && (k.Rune == 'y' || k.Rune == 'Y') {
+			return m.resetErrors(label)
+		}
+		m.Status = "Canceled"
+		return nil
+	}
+
+	switch k.Special {
+	case KeyUp:
+		m.move(-1)
+	case KeyDown:
+		m.move(1)
+	case KeyLeft, KeyPageUp:
+		return m.loadHistory(m.history.page - 1)
+	case KeyRight, KeyPageDown:
+		return m.loadHistory(m.history.page + 1)
+	case KeyTab:
+		if m.Focus == PaneAccounts {
+			m.Focus = PaneHistory
+		} else {
+			m.Focus = PaneAccounts
+		}
+	case KeyEnter:
+		return m.loadHistory(1)
+	case KeyEscape:
+		m.ShowHelp = false
+	case KeyRune:
+		switch k.Rune {
+		case 'q':
+			m.Quit = true
+		case 'k':
+			m.move(-1)
+		case 'j':
+			m.move(1)
+		case 'p':
+			return m.loadHistory(m.history.page - 1)
+		case 'n':
+			return m.loadHistory(m.history.page + 1)
+		case 'h':
+			return m.loadHistory(1)
+		case 's':
+			return m.startSync()
+		case 'i':
+			return m.getInfo()
+		case 'r':
+			if a := m.selected(); a != nil {
+				m.confirm = a.Label
+				m.Status = fmt.Sprintf("Reset the error count of %s? (y/N)", a.Label)
+			}
+		case '?':
+			m.ShowHelp = !m.ShowHelp
+		}
+	}
+	return nil
+}
+
+// move moves the selection, or scrolls the history when it has the focus.
+func (m *Model) move(d int) {
+	if m.Focus == PaneHistory {
+		m.history.scroll += d
+		if m.history.scroll < 0 {
+			m.history.scroll = 0
+		}
+		if h := m.history.history; h != nil && m.history.scroll >= len(h.Entries) {
+			m.history.scroll = len(h.Entries) - 1
+		}
+		return
+	}
+
+	m.Selected += d
+	if m.Selected < 0 {
+		m.Selected = 0
+	}
+	if m.Selected >= len(m.Accounts) {
+		m.Selected = len(m.Accounts) - 1
+	}
+}
+
+func (m *Model) startSync() Cmd {
+	if m.Sync == nil {
+		return nil
+	}
+	m.pending++
+	m.Status = "Synchronizing…"
+	sync := m.Sync
+	return func(ctx context.Context) Msg {
+		r, err := sync(ctx)
+		return SyncMsg{Result: r, Err: err}
+	}
+}
+
+// loadHistory loads a page of history of the selected account.
+func (m *Model) loadHistory(page int) Cmd {
+	a := m.selected()
+	if a == nil || page < 1 {
+		return nil
+	}
+	if h := m.history.history; h != nil && m.history.label == a.Label && page > h.PageTotal && h.PageTotal > 0 {
+		return nil
+	}
+
+	m.history = historyState{
+		label:   a.Label,
+		page:    page,
+		loading: true,
+	}
+	m.ShowHelp = false
+	m.pending++
+
+	label, otp := a.Label, a.OTP
+	return func(ctx context.Context) Msg {
+		h, err := otp.GetHistory(ctx, page)
+		return HistoryMsg{Label: label, Page: page, History: h, Err: err}
+	}
+}
+
+func (m *Model) getInfo() Cmd {
+	a := m.selected()
+	if a == nil {
+		return nil
+	}
+	m.pending++
+	m.Status = fmt.Sprintf("%s: asking the server…", a.Label)
+
+	label, otp := a.Label, a.OTP
+	return func(ctx context.Context) Msg {
+		info, err := otp.GetInformation(ctx)
+		return InfoMsg{Label: label, Info: info, Err: err}
+	}
+}
+
+func (m *Model) resetErrors(label string) Cmd {
+	var otp uotp.UOTP
+	for _, a := range m.Accounts {
+		if a.Label == label {
+			otp = a.OTP
+		}
+	}
+	if otp == nil {
+		return nil
+	}
+	m.pending++
+	m.Status = fmt.Sprintf("%s: resetting the error count…", label)
+
+	return func(ctx context.Context) Msg {
+		return ResetMsg{Label: label, Err: otp.ResetErrorCount(ctx)}
+	}
+}
@@ -0,0 +1,230 @@
+package dashboard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RyuaNerin/uotp"
+)
+
+// fakeOTP answers the calls of the dashboard. The methods it does not
+// override panic.
+type fakeOTP struct {
+	uotp.UOTP
+	serial string
+	pages  int
+	resets int
+}
+
+func (f *fakeOTP) GetSerialNumber() string { return f.serial }
+
+func (f *fakeOTP) Token() uotp.Token {
+	return uotp.Token{Code: "123-4567", Digits: "1234567", Remaining: 4 * time.Second}
+}
+
+func (f *fakeOTP) GetHistory(ctx context.Context, page int) (*uotp.History, error) {
+	if page > f.pages {
+		return nil, errors.New("no such page")
+	}
+	h := &uotp.History{PageCurrent: page, PageTotal: f.pages}
+	for i := 0; i < 3; i++ {
+		h.Entries = append(h.Entries, uotp.HistoryEntry{
+			At:   time.Date(2024, 5, page, 12, i, 0, 0, time.UTC),
+			Type: "인증",
+			Name: fmt.Sprintf("service %d-%d", page, i),
+		})
+	}
+	return h, nil
+}
+
+func (f *fakeOTP) GetInformation(ctx context.Context) (*uotp.Information, error) {
+	return &uotp.Information{OID: 42, Partner: "partner"}, nil
+}
+
+func (f *fakeOTP) ResetErrorCount(ctx context.Context) error {
+	f.resets++
+	return nil
+}
+
+func newTestModel() (*Model, []*fakeOTP) {
+	fakes := []*fakeOTP{
+		{serial: "1784-5365-6261", pages: 2},
+		{serial: "1111-2222-3333", pages: 1},
+	}
+	m := New([]Account{
+		{Label: "default", OTP: fakes[0]},
+		{Label: "work", OTP: fakes[1]},
+	}, func(ctx context.Context) (*uotp.SyncResult, error) {
+		return &uotp.SyncResult{Offset: time.Second, Source: uotp.ServerTimeSource}, nil
+	})
+	return m, fakes
+}
+
+// run runs cmd and applies its result, as the CLI does.
+func run(t *testing.T, m *Model, cmd Cmd) {
+	t.Helper()
+	if cmd == nil {
+		t.Fatal("no command")
+	}
+	m.Update(cmd(context.Background()))
+}
+
+func press(m *Model, keys ...Key) Cmd {
+	var cmd Cmd
+	for _, k := range keys {
+		cmd = m.Update(KeyMsg(k))
+	}
+	return cmd
+}
+
+func TestModelInit(t *testing.T) {
+	m, _ := newTestModel()
+
+	cmds := m.Init()
+	if len(cmds) != 2 || !m.Busy() {
+		t.Fatalf("%d commands, busy %v", len(cmds), m.Busy())
+	}
+	for _, cmd := range cmds {
+		run(t, m, cmd)
+	}
+	if m.Busy() || m.lastSync == nil || m.history.history == nil || m.history.label != "default" {
+		t.Errorf("after init: %+v", m)
+	}
+}
+
+func TestModelHistoryPaging(t *testing.T) {
+	m, _ := newTestModel()
+
+	run(t, m, press(m, Key{Special: KeyEnter}))
+	if m.history.page != 1 || len(m.history.history.Entries) != 3 {
+		t.Fatalf("history %+v", m.history)
+	}
+
+	run(t, m, press(m, Rune('n')))
+	if m.history.page != 2 || m.history.history.PageCurrent != 2 {
+		t.Fatalf("next page: %+v", m.history)
+	}
+	if cmd := press(m, Rune('n')); cmd != nil {
+		t.Error("paged past the last page")
+	}
+	run(t, m, press(m, Key{Special: KeyLeft}))
+	if m.history.page != 1 {
+		t.Errorf("previous page: %+v", m.history)
+	}
+	if cmd := press(m, Rune('p')); cmd != nil {
+		t.Error("paged before the first page")
+	}
+
+	// A page that arrives after another account was selected is dropped.
+	cmd := press(m, Key{Special: KeyEnter})
+	press(m, Key{Special: KeyDown})
+	run(t, m, press(m, Rune('h')))
+	run(t, m, cmd)
+	if m.history.label != "work" || m.Busy() {
+		t.Errorf("history of %s, busy %v", m.history.label, m.Busy())
+	}
+}
+
+func TestModelSelectAndScroll(t *testing.T) {
+	m, _ := newTestModel()
+
+	press(m, Key{Special: KeyUp})
+	if m.Selected != 0 {
+		t.Errorf("selected %d", m.Selected)
+	}
+	press(m, Rune('j'), Rune('j'))
+	if m.Selected != 1 {
+		t.Errorf("selected %d", m.Selected)
+	}
+
+	run(t, m, press(m, Key{Special: KeyEnter}))
+	press(m, Key{Special: KeyTab}, Rune('j'), Rune('j'), Rune('j'), Rune('j'))
+	if m.Selected != 1 || m.history.scroll != 2 {
+		t.Errorf("selected %d, scroll %d", m.Selected, m.history.scroll)
+	}
+}
+
+func TestModelResetConfirm(t *testing.T) {
+	m, fakes := newTestModel()
+
+	if cmd := press(m, Rune('r'), Rune('n')); cmd != nil || fakes[0].resets != 0 {
+		t.Fatal("reset without confirmation")
+	}
+	run(t, m, press(m, Rune('r'), Rune('y')))
+	if fakes[0].resets != 1 || !strings.Contains(m.Status, "reset") {
+		t.Errorf("resets %d, status %q", fakes[0].resets, m.Status)
+	}
+}
+
+func TestModelInfoAndQuit(t *testing.T) {
+	m, _ := newTestModel()
+
+	run(t, m, press(m, Rune('i')))
+	if m.info["default"] == nil || !strings.Contains(m.Status, "OID 42") {
+		t.Errorf("status %q", m.Status)
+	}
+
+	press(m, Key{Special: KeyCtrlC})
+	if !m.Quit {
+		t.Error("ctrl-c did not quit")
+	}
+}
+
+func TestModelView(t *testing.T) {
+	m, _ := newTestModel()
+	m.Update(ResizeMsg{Width: 60, Height: 16})
+	run(t, m, press(m, Key{Special: KeyEnter}))
+
+	lines := m.View()
+	if len(lines) != 16 {
+		t.Fatalf("%d lines", len(lines))
+	}
+	for i, l := range lines {
+		if width(l) > 60 {
+			t.Errorf("line %d is %d columns wide: %q", i, width(l), l)
+		}
+	}
+
+	view := strings.Join(lines, "\n")
+	for _, want := range []string{"> default", "123-4567", "History of default, page 1/2", "service 1-0", keysLine[:20]} {
+		if !strings.Contains(view, want) {
+			t.Errorf("view lacks %q:\n%s", want, view)
+		}
+	}
+
+	m.Update(ResizeMsg{Width: 80, Height: 24})
+	press(m, Rune('?'))
+	if view := strings.Join(m.View(), "\n"); !strings.Contains(view, "show or hide this help") {
+		t.Errorf("help not shown:\n%s", view)
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	got := ParseKeys([]byte("a\x1b[A\x1b[6~\t\r\x03\x1b[1;5Pé\x1b"))
+	want := []Key{
+		Rune('a'),
+		{Special: KeyUp},
+		{Special: KeyPageDown},
+		{Special: KeyTab},
+		{Special: KeyEnter},
+		{Special: KeyCtrlC},
+		Rune('é'),
+		{Special: KeyEscape},
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	if got := truncate("인증 service", 5); got != "인증 " {
+		t.Errorf("got %q", got)
+	}
+	if got := truncate("abc", 5); got != "abc" {
+		t.Errorf("got %q", got)
+	}
+}
@@ -0,0 +1,188 @@
+package dashboard
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/RyuaNerin/uotp"
+)
+
+const barWidth = 10
+
+var helpLines = []string{
+	"↑ ↓, k j      select an account, or scroll the history",
+	"tab           switch between the accounts and the history",
+	"enter, h      load the history of the selected account",
+	"← →, p n      previous and next page of history",
+	"s             synchronize the time",
+	"i             ask the server about the selected account",
+	"r             reset the error count of the selected account",
+	"?             show or hide this help",
+	"q, ctrl-c     quit",
+}
+
+const keysLine = "↑↓ select  tab pane  enter history  ←→ page  s sync  i info  r reset  ? help  q quit"
+
+// View draws the dashboard as Height lines of at most Width columns.
+func (m *Model) View() []string {
+	var lines []string
+	add := func(format string, a ...interface{}) {
+		lines = append(lines, fmt.Sprintf(format, a...))
+	}
+	rule := strings.Repeat("─", m.Width)
+
+	title := "μOTP+"
+	if m.Busy() {
+		title += "  working…"
+	}
+	clock := m.Now.Format("15:04:05")
+	add("%s%s%s", title, strings.Repeat(" ", max(1, m.Width-width(title)-width(clock))), clock)
+
+	add("%s", paneTitle("Accounts", m.Focus == PaneAccounts))
+	for i, a := range m.Accounts {
+		cursor := " "
+		if i == m.Selected {
+			cursor = ">"
+		}
+		t := m.tokens[i]
+		add("%s %-12s %-15s %s  %s %2.0fs", cursor, a.Label, a.OTP.GetSerialNumber(), t.Code,
+			bar(t.Remaining), math.Ceil(t.Remaining.Seconds()))
+	}
+	if len(m.Accounts) == 0 {
+		add("  no account")
+	}
+	if r := m.lastSync; r != nil {
+		add("  time: %s ± %s from %s", r.Offset.Round(time.Millisecond), r.Uncertainty.Round(time.Millisecond), r.Source)
+	}
+	add("%s", rule)
+
+	// The history takes the lines left above the status and the keys.
+	rows := m.Height - len(lines) - 3
+	if m.ShowHelp {
+		add("%s", paneTitle("Help", false))
+		for i := 0; i < rows-1 && i < len(helpLines); i++ {
+			add("  %s", helpLines[i])
+		}
+	} else {
+		add("%s", paneTitle(m.historyTitle(), m.Focus == PaneHistory))
+		lines = append(lines, m.historyLines(rows-1)...)
+	}
+	for len(lines) < m.Height-3 {
+		lines = append(lines, "")
+	}
+
+	add("%s", rule)
+	add("%s", m.Status)
+	add("%s", keysLine)
+
+	for i, l := range lines {
+		lines[i] = truncate(l, m.Width)
+	}
+	if len(lines) > m.Height && m.Height > 0 {
+		lines = lines[:m.Height]
+	}
+	return lines
+}
+
+func paneTitle(title string, focused bool) string {
+	if focused {
+		return "[" + title + "]"
+	}
+	return " " + title
+}
+
+func (m *Model) historyTitle() string {
+	h := &m.history
+	if h.label == "" {
+		return "History"
+	}
+	title := fmt.Sprintf("History of %s, page %d", h.label, h.page)
+	if h.history != nil {
+		title = fmt.Sprintf("History of %s, page %d/%d, %s ~ %s", h.label, h.history.PageCurrent, h.history.PageTotal,
+			h.history.PeriodStart.Format("2006-01-02"), h.history.PeriodEnd.Format("2006-01-02"))
+	}
+	return title
+}
+
+func (m *Model) historyLines(rows int) []string {
+	h := &m.history
+	switch {
+	case rows <= 0:
+		return nil
+	case h.label == "":
+		return []string{"  press enter to load the history of the selected account"}
+	case h.loading:
+		return []string{"  loading…"}
+	case h.err != nil:
+		return []string{"  " + h.err.Error()}
+	case h.history == nil || len(h.history.Entries) == 0:
+		return []string{"  no entries"}
+	}
+
+	entries := h.history.Entries[h.scroll:]
+	if len(entries) > rows {
+		entries = entries[:rows]
+	}
+	lines := make([]string, len(entries))
+	for i, e := range entries {
+		lines[i] = fmt.Sprintf("  %s  %s  %s", e.At.Format("2006-01-02 15:04:05"), e.Type, e.Name)
+	}
+	return lines
+}
+
+func bar(remaining time.Duration) string {
+	filled := int(int64(barWidth) * int64(remaining) / int64(uotp.TokenStep))
+	filled = min(max(filled, 0), barWidth)
+	return strings.Repeat("█", filled) + strings.Repeat("░", barWidth-filled)
+}
+
+// runeWidth is the number of columns r takes: two for Hangul and other
+// wide East Asian characters, one otherwise.
+func runeWidth(r rune) int {
+	if unicode.Is(unicode.Hangul, r) || unicode.Is(unicode.Han, r) ||
+		unicode.Is(unicode.Hiragana, r) || unicode.Is(unicode.Katakana, r) ||
+		r >= 0xff01 && r <= 0xff60 {
+		return 2
+	}
+	return 1
+}
+
+func width(s string) int {
+	n := 0
+	for _, r := range s {
+		n += runeWidth(r)
+	}
+	return n
+}
+
+// truncate cuts s to at most w columns.
+func truncate(s string, w int) string {
+	if w <= 0 {
+		return s
+	}
+	n := 0
+	for i, r := range s {
+		n += runeWidth(r)
+		if n > w {
+			return s[:i]
+		}
+	}
+	return s
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
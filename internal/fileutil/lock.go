@@ -0,0 +1,56 @@
+package fileutil
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// ErrLocked is returned by TryLock when another process holds the lock.
+var ErrLocked = errors.New("fileutil: locked by another process")
+
+// Lock is an advisory lock on a file, held until Unlock. Processes that do
+// not take it are not kept out.
+//
+// The locked file is not the one being protected, which WriteFile replaces,
+// but a lock file next to it that is never removed.
+type Lock struct {
+	f *os.File
+}
+
+// LockFile takes the lock on the file at path, creating it if needed, and
+// waits while another process holds it.
+func LockFile(path string) (*Lock, error) {
+	return lockFile(path, true)
+}
+
+// TryLockFile is like LockFile, but returns ErrLocked instead of waiting.
+func TryLockFile(path string) (*Lock, error) {
+	return lockFile(path, false)
+}
+
+func lockFile(path string, wait bool) (*Lock, error) {
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = lock(f, wait); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &Lock{f: f}, nil
+}
+
+// Unlock releases the lock.
+func (l *Lock) Unlock() error {
+	err := unlock(l.f)
+	if closeErr := l.f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
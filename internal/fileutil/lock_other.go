@@ -0,0 +1,17 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly && !windows
+
+package fileutil
+
+import "os"
+
+// LockSupported reports whether Lock keeps other processes out. Without
+// flock or LockFileEx, the lock file is created but not locked.
+const LockSupported = false
+
+func lock(f *os.File, wait bool) error {
+	return nil
+}
+
+func unlock(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,50 @@
+package fileutil
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLock(t *testing.T) {
+	if !LockSupported {
+		t.Skip("locks are not supported")
+	}
+	path := filepath.Join(t.TempDir(), "config.json.lock")
+
+	l, err := LockFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = TryLockFile(path); !errors.Is(err, ErrLocked) {
+		t.Fatalf("locked twice: %v", err)
+	}
+
+	locked := make(chan *Lock)
+	go func() {
+		l, err := LockFile(path)
+		if err != nil {
+			t.Error(err)
+		}
+		locked <- l
+	}()
+
+	select {
+	case <-locked:
+		t.Fatal("LockFile did not wait")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if err = l.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case l = <-locked:
+	case <-time.After(5 * time.Second):
+		t.Fatal("LockFile still waits after Unlock")
+	}
+	if l != nil {
+		l.Unlock()
+	}
+}
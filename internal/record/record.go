@@ -0,0 +1,403 @@
+// Package record encodes and decodes the fixed-width ASCII records
+// used in μOTP payloads.
+//
+// A record is described by a Layout, a list of Fields. Encoder and Decoder
+// walk the fields in order; every call consumes exactly one field and checks
+// that its kind matches. The first error is kept and later calls do nothing,
+// so callers only need to check Err once at the end.
+package record
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/korean"
+)
+
+type Kind uint8
+
+const (
+	// ASCII is left-aligned text padded with spaces.
+	ASCII Kind = iota
+	// Numeric is a right-aligned unsigned integer padded with zeros.
+	Numeric
+	// LeftNumeric is a left-aligned unsigned integer padded with spaces.
+	LeftNumeric
+	// Hex is lowercase hexadecimal bytes padded with spaces.
+	Hex
+	// EUCKR is left-aligned EUC-KR text padded with spaces.
+	EUCKR
+)
+
+func (k Kind) String() string {
+	switch k {
+	case ASCII:
+		return "ascii"
+	case Numeric:
+		return "numeric"
+	case LeftNumeric:
+		return "left-numeric"
+	case Hex:
+		return "hex"
+	case EUCKR:
+		return "euc-kr"
+	}
+	return "kind(" + strconv.Itoa(int(k)) + ")"
+}
+
+// Variable is the width of a field that takes every byte not used by the
+// other fields. A layout can have at most one such field.
+const Variable = -1
+
+type Field struct {
+	Name  string
+	Kind  Kind
+	Width int
+}
+
+type Layout []Field
+
+// Size returns the total width of the fixed-width fields.
+func (l Layout) Size() int {
+	n := 0
+	for _, f := range l {
+		if f.Width != Variable {
+			n += f.Width
+		}
+	}
+	return n
+}
+
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, 0, 512)
+		return &b
+	},
+}
+
+// GetBuffer returns an empty buffer from the pool.
+func GetBuffer() *[]byte {
+	b := bufferPool.Get().(*[]byte)
+	*b = (*b)[:0]
+	return b
+}
+
+// PutBuffer returns b to the pool. b must not be used afterwards.
+func PutBuffer(b *[]byte) {
+	bufferPool.Put(b)
+}
+
+func fieldError(f Field, format string, a ...interface{}) error {
+	return fmt.Errorf("field %s (%s %d): %s", f.Name, f.Kind, f.Width, fmt.Sprintf(format, a...))
+}
+
+func takeField(layout Layout, index *int, err *error, kinds ...Kind) (Field, bool) {
+	if *err != nil {
+		return Field{}, false
+	}
+	if *index >= len(layout) {
+		*err = fmt.Errorf("no field left in layout of %d fields", len(layout))
+		return Field{}, false
+	}
+
+	f := layout[*index]
+	*index++
+
+	if len(kinds) == 0 {
+		return f, true
+	}
+	for _, k := range kinds {
+		if f.Kind == k {
+			return f, true
+		}
+	}
+	*err = fieldError(f, "accessed as %s", kinds[0])
+	return Field{}, false
+}
+
+func trimSpace(b []byte) []byte {
+	for len(b) > 0 && b[0] == ' ' {
+		b = b[1:]
+	}
+	for len(b) > 0 && b[len(b)-1] == ' ' {
+		b = b[:len(b)-1]
+	}
+	return b
+}
+
+// Encoder appends the fields of a layout to a buffer.
+type Encoder struct {
+	layout Layout
+	buf    []byte
+	field  int
+	err    error
+	// euckr is created by the first EUCKR field, and reused by the next.
+	euckr *encoding.Encoder
+}
+
+// NewEncoder returns an encoder that appends to dst.
+func NewEncoder(layout Layout, dst []byte) Encoder {
+	return Encoder{
+		layout: layout,
+		buf:    dst,
+	}
+}
+
+// Bytes returns the buffer with every field encoded so far.
+func (e *Encoder) Bytes() []byte {
+	return e.buf
+}
+
+// Err returns the first error, or an error if some fields were not written.
+func (e *Encoder) Err() error {
+	if e.err == nil && e.field != len(e.layout) {
+		return fmt.Errorf("%d of %d fields were written", e.field, len(e.layout))
+	}
+	return e.err
+}
+
+func (e *Encoder) pad(f Field, n int) {
+	for ; n < f.Width; n++ {
+		e.buf = append(e.buf, ' ')
+	}
+}
+
+// Blank writes a field filled with spaces.
+func (e *Encoder) Blank() {
+	f, ok := takeField(e.layout, &e.field, &e.err)
+	if !ok {
+		return
+	}
+	e.pad(f, 0)
+}
+
+// String writes an ASCII field.
+func (e *Encoder) String(s string) {
+	f, ok := takeField(e.layout, &e.field, &e.err, ASCII)
+	if !ok {
+		return
+	}
+	if f.Width != Variable && len(s) > f.Width {
+		e.err = fieldError(f, "%d bytes do not fit", len(s))
+		return
+	}
+	e.buf = append(e.buf, s...)
+	e.pad(f, len(s))
+}
+
+// Uint writes a Numeric or LeftNumeric field.
+func (e *Encoder) Uint(v uint64) {
+	f, ok := takeField(e.layout, &e.field, &e.err, Numeric, LeftNumeric)
+	if !ok {
+		return
+	}
+
+	var digits [20]byte
+	d := strconv.AppendUint(digits[:0], v, 10)
+	if f.Width != Variable && len(d) > f.Width {
+		e.err = fieldError(f, "%d does not fit", v)
+		return
+	}
+
+	if f.Kind == Numeric {
+		for n := len(d); n < f.Width; n++ {
+			e.buf = append(e.buf, '0')
+		}
+		e.buf = append(e.buf, d...)
+	} else {
+		e.buf = append(e.buf, d...)
+		e.pad(f, len(d))
+	}
+}
+
+// Hex writes b as a Hex field.
+func (e *Encoder) Hex(b []byte) {
+	f, ok := takeField(e.layout, &e.field, &e.err, Hex)
+	if !ok {
+		return
+	}
+	n := hex.EncodedLen(len(b))
+	if f.Width != Variable && n > f.Width {
+		e.err = fieldError(f, "%d bytes do not fit", len(b))
+		return
+	}
+
+	start := len(e.buf)
+	e.buf = append(e.buf, make([]byte, n)...)
+	hex.Encode(e.buf[start:], b)
+	e.pad(f, n)
+}
+
+// EUCKR writes s encoded as EUC-KR. The encoder keeps one EUC-KR
+// transformer for all its EUCKR fields.
+func (e *Encoder) EUCKR(s string) {
+	f, ok := takeField(e.layout, &e.field, &e.err, EUCKR)
+	if !ok {
+		return
+	}
+	if e.euckr == nil {
+		e.euckr = korean.EUCKR.NewEncoder()
+	}
+	b, err := e.euckr.String(s)
+	if err != nil {
+		e.err = fieldError(f, "%v", err)
+		return
+	}
+	if f.Width != Variable && len(b) > f.Width {
+		e.err = fieldError(f, "%d bytes do not fit", len(b))
+		return
+	}
+	e.buf = append(e.buf, b...)
+	e.pad(f, len(b))
+}
+
+// Decoder reads the fields of a layout from a buffer.
+type Decoder struct {
+	layout   Layout
+	buf      []byte
+	variable int
+	field    int
+	err      error
+	// euckr is created by the first EUCKR field, and reused by the next.
+	euckr *encoding.Decoder
+}
+
+// NewDecoder returns a decoder reading from src. Bytes after the last
+// field are ignored unless the layout has a Variable field.
+func NewDecoder(layout Layout, src []byte) Decoder {
+	d := Decoder{
+		layout: layout,
+		buf:    src,
+	}
+	if size := layout.Size(); len(src) < size {
+		d.err = fmt.Errorf("needs %d bytes, got %d", size, len(src))
+	} else {
+		d.variable = len(src) - size
+	}
+	return d
+}
+
+// Err returns the first error.
+func (d *Decoder) Err() error {
+	return d.err
+}
+
+// Rest returns the bytes after the fields decoded so far.
+func (d *Decoder) Rest() []byte {
+	return d.buf
+}
+
+func (d *Decoder) take(kinds ...Kind) (Field, []byte, bool) {
+	f, ok := takeField(d.layout, &d.field, &d.err, kinds...)
+	if !ok {
+		return Field{}, nil, false
+	}
+	w := f.Width
+	if w == Variable {
+		w = d.variable
+	}
+	b := d.buf[:w:w]
+	d.buf = d.buf[w:]
+	return f, b, true
+}
+
+// Bytes returns the raw bytes of a field of any kind. They alias the source.
+func (d *Decoder) Bytes() []byte {
+	_, b, _ := d.take()
+	return b
+}
+
+// Skip skips a field of any kind.
+func (d *Decoder) Skip() {
+	d.take()
+}
+
+// String reads an ASCII field without surrounding spaces.
+func (d *Decoder) String() string {
+	_, b, ok := d.take(ASCII)
+	if !ok {
+		return ""
+	}
+	return string(trimSpace(b))
+}
+
+// Uint reads a Numeric or LeftNumeric field.
+func (d *Decoder) Uint() uint64 {
+	f, b, ok := d.take(Numeric, LeftNumeric)
+	if !ok {
+		return 0
+	}
+
+	b = trimSpace(b)
+	if len(b) == 0 {
+		d.err = fieldError(f, "empty")
+		return 0
+	}
+
+	var v uint64
+	for _, c := range b {
+		if c < '0' || '9' < c {
+			d.err = fieldError(f, "malformed %q", b)
+			return 0
+		}
+		n := v*10 + uint64(c-'0')
+		if n/10 != v {
+			d.err = fieldError(f, "overflow %q", b)
+			return 0
+		}
+		v = n
+	}
+	return v
+}
+
+// Int reads a Numeric or LeftNumeric field that must fit in an int.
+func (d *Decoder) Int() int {
+	v := d.Uint()
+	if int(v) < 0 || uint64(int(v)) != v {
+		d.err = fmt.Errorf("field %s: %d overflows int", d.layout[d.field-1].Name, v)
+		return 0
+	}
+	return int(v)
+}
+
+// Hex reads a Hex field into dst and returns the number of bytes written.
+func (d *Decoder) Hex(dst []byte) int {
+	f, b, ok := d.take(Hex)
+	if !ok {
+		return 0
+	}
+
+	b = trimSpace(b)
+	if hex.DecodedLen(len(b)) > len(dst) {
+		d.err = fieldError(f, "%d bytes do not fit in %d", hex.DecodedLen(len(b)), len(dst))
+		return 0
+	}
+	n, err := hex.Decode(dst, b)
+	if err != nil {
+		d.err = fieldError(f, "%v", err)
+		return 0
+	}
+	return n
+}
+
+// EUCKR reads an EUC-KR field without surrounding spaces. The decoder
+// keeps one EUC-KR transformer for all its EUCKR fields.
+func (d *Decoder) EUCKR() string {
+	f, b, ok := d.take(EUCKR)
+	if !ok {
+		return ""
+	}
+	if d.euckr == nil {
+		d.euckr = korean.EUCKR.NewDecoder()
+	}
+	s, err := d.euckr.Bytes(trimSpace(b))
+	if err != nil {
+		d.err = fieldError(f, "%v", err)
+		return ""
+	}
+	return string(bytes.TrimSpace(s))
+}
@@ -0,0 +1,159 @@
+package record
+
+import (
+	"bytes"
+	"testing"
+)
+
+var testLayout = Layout{
+	{Name: "text", Kind: ASCII, Width: 6},
+	{Name: "number", Kind: Numeric, Width: 5},
+	{Name: "left", Kind: LeftNumeric, Width: 5},
+	{Name: "hex", Kind: Hex, Width: 8},
+	{Name: "korean", Kind: EUCKR, Width: 10},
+}
+
+func TestRoundTrip(t *testing.T) {
+	e := NewEncoder(testLayout, nil)
+	e.String("abc")
+	e.Uint(42)
+	e.Uint(7)
+	e.Hex([]byte{0xde, 0xad})
+	e.EUCKR("인증")
+	if err := e.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []byte("abc   000427    dead    \xc0\xce\xc1\xf5      ")
+	if !bytes.Equal(e.Bytes(), want) {
+		t.Fatalf("encoded %q, want %q", e.Bytes(), want)
+	}
+
+	var h [4]byte
+	d := NewDecoder(testLayout, e.Bytes())
+	text := d.String()
+	number := d.Uint()
+	left := d.Int()
+	n := d.Hex(h[:])
+	korean := d.EUCKR()
+	if err := d.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if text != "abc" || number != 42 || left != 7 || !bytes.Equal(h[:n], []byte{0xde, 0xad}) || korean != "인증" {
+		t.Errorf("decoded %q %d %d %x %q", text, number, left, h[:n], korean)
+	}
+}
+
+func TestEUCKRFields(t *testing.T) {
+	layout := Layout{
+		{Name: "type", Kind: EUCKR, Width: 10},
+		{Name: "name", Kind: EUCKR, Width: 10},
+	}
+
+	// The transformer of the first field is reused by the second.
+	e := NewEncoder(layout, nil)
+	e.EUCKR("인증")
+	e.EUCKR("은행")
+	if err := e.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewDecoder(layout, e.Bytes())
+	typ, name := d.EUCKR(), d.EUCKR()
+	if err := d.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if typ != "인증" || name != "은행" {
+		t.Errorf("decoded %q %q", typ, name)
+	}
+}
+
+func TestVariable(t *testing.T) {
+	layout := Layout{
+		{Name: "body", Kind: ASCII, Width: Variable},
+		{Name: "trailer", Kind: Numeric, Width: 2},
+	}
+
+	e := NewEncoder(layout, nil)
+	e.String("a|b|c")
+	e.Uint(9)
+	if err := e.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewDecoder(layout, e.Bytes())
+	body := d.String()
+	trailer := d.Uint()
+	if err := d.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if body != "a|b|c" || trailer != 9 {
+		t.Errorf("decoded %q %d", body, trailer)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	d := NewDecoder(testLayout, []byte("short"))
+	if d.Skip(); d.Err() == nil {
+		t.Error("expected an error for a short record")
+	}
+
+	d = NewDecoder(testLayout, []byte("abc   0x042"+"                         "))
+	d.Skip()
+	if d.Uint(); d.Err() == nil {
+		t.Error("expected an error for a malformed number")
+	}
+
+	e := NewEncoder(testLayout, nil)
+	if e.String("too long"); e.Err() == nil {
+		t.Error("expected an error for a value wider than its field")
+	}
+
+	e = NewEncoder(testLayout, nil)
+	if e.Uint(1); e.Err() == nil {
+		t.Error("expected an error for a kind mismatch")
+	}
+
+	e = NewEncoder(testLayout, nil)
+	if e.String("abc"); e.Err() == nil {
+		t.Error("expected an error for missing fields")
+	}
+}
+
+func TestEncodeAllocs(t *testing.T) {
+	layout := testLayout[:4]
+
+	allocs := testing.AllocsPerRun(100, func() {
+		buf := GetBuffer()
+		e := NewEncoder(layout, *buf)
+		e.String("abc")
+		e.Uint(42)
+		e.Blank()
+		e.Hex([]byte{0xde, 0xad})
+		*buf = e.Bytes()
+		PutBuffer(buf)
+	})
+	if allocs != 0 {
+		t.Errorf("encoding allocates %v times", allocs)
+	}
+}
+
+func TestDecodeAllocs(t *testing.T) {
+	layout := Layout{testLayout[1], testLayout[2], testLayout[3]}
+	src := []byte("000427    dead    ")
+
+	var h [4]byte
+	allocs := testing.AllocsPerRun(100, func() {
+		d := NewDecoder(layout, src)
+		d.Uint()
+		d.Uint()
+		d.Hex(h[:])
+		if d.Err() != nil {
+			t.Fatal(d.Err())
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("decoding allocates %v times", allocs)
+	}
+}
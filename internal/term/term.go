@@ -0,0 +1,44 @@
+// Package term puts a terminal in raw mode and reads its size. It only
+// uses the standard library, so it supports the platforms whose termios
+// ioctls are in package syscall.
+package term
+
+import "errors"
+
+// ErrUnsupported is returned on platforms without termios.
+var ErrUnsupported = errors.New("term: not supported on this platform")
+
+// State is the state of a terminal, to restore it after MakeRaw.
+type State struct {
+	state
+}
+
+// IsTerminal reports whether fd is a terminal.
+func IsTerminal(fd int) bool {
+	_, err := getState(fd)
+	return err == nil
+}
+
+// MakeRaw puts the terminal fd in raw mode: input is neither echoed nor
+// buffered by line, and signals are not generated by keys. It returns the
+// previous state.
+func MakeRaw(fd int) (*State, error) {
+	old, err := getState(fd)
+	if err != nil {
+		return nil, err
+	}
+	if err = setState(fd, old.raw()); err != nil {
+		return nil, err
+	}
+	return &State{old}, nil
+}
+
+// Restore puts the terminal fd back in the state returned by MakeRaw.
+func Restore(fd int, s *State) error {
+	return setState(fd, s.state)
+}
+
+// Size returns the width and height of the terminal fd, in characters.
+func Size(fd int) (width, height int, err error) {
+	return size(fd)
+}
@@ -0,0 +1,21 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly
+
+package term
+
+type state struct{}
+
+func getState(fd int) (state, error) {
+	return state{}, ErrUnsupported
+}
+
+func setState(fd int, s state) error {
+	return ErrUnsupported
+}
+
+func (s state) raw() state {
+	return s
+}
+
+func size(fd int) (int, int, error) {
+	return 0, 0, ErrUnsupported
+}
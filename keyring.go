@@ -0,0 +1,345 @@
+package uotp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/RyuaNerin/uotp/internal/fileutil"
+)
+
+var (
+	ErrAccountNotFound = errors.New("account not found")
+	ErrDuplicateLabel  = errors.New("label already in use")
+	ErrDuplicateSerial = errors.New("serial number already in the keyring")
+	ErrInvalidLabel    = errors.New("invalid label")
+)
+
+// KeyringAccount is an account of a keyring with its label.
+type KeyringAccount struct {
+	Label string `json:"label"`
+	Account
+}
+
+// keyringFile is the format of the file a keyring is saved to.
+type keyringFile struct {
+	Accounts []KeyringAccount `json:"accounts"`
+}
+
+type keyringEntry struct {
+	label string
+	otp   *uotp
+}
+
+// Keyring holds several accounts, each with a unique label. It is safe for
+// concurrent use.
+//
+// Every account of a keyring is created with the same options, so they talk
+// to the same server and share the offset measured by SyncTime.
+type Keyring struct {
+	mu      sync.RWMutex
+	opts    []Option
+	entries []*keyringEntry
+	sync    *SyncResult
+}
+
+// NewKeyring returns an empty keyring. opts are applied to every account.
+func NewKeyring(opts ...Option) *Keyring {
+	return &Keyring{
+		opts: opts,
+	}
+}
+
+// LoadKeyring reads a keyring saved by Keyring.Save. A missing file is an
+// empty keyring.
+func LoadKeyring(path string, opts ...Option) (*Keyring, error) {
+	k := NewKeyring(opts...)
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return k, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var f keyringFile
+	err = json.Unmarshal(data, &f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	for i := range f.Accounts {
+		_, err = k.Add(f.Accounts[i].Label, &f.Accounts[i].Account)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return k, nil
+}
+
+// Save writes every account to path. The file is replaced at once, so it
+// is never left half written.
+func (k *Keyring) Save(path string) error {
+	data, err := json.MarshalIndent(k.export(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return fileutil.WriteFile(path, append(data, '\n'), 0600)
+}
+
+func (k *Keyring) export() *keyringFile {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	f := &keyringFile{
+		Accounts: make([]KeyringAccount, len(k.entries)),
+	}
+	for i, e := range k.entries {
+		f.Accounts[i] = KeyringAccount{
+			Label:   e.label,
+			Account: e.otp.GetAccount(),
+		}
+	}
+	return f
+}
+
+// normalizeSerial drops the separators of a serial number.
+func normalizeSerial(s string) string {
+	return strings.NewReplacer("-", "", " ", "").Replace(s)
+}
+
+func validLabel(label string) error {
+	if label == "" || strings.TrimSpace(label) != label {
+		return fmt.Errorf("%w: %q", ErrInvalidLabel, label)
+	}
+	return nil
+}
+
+// find returns the index of the account with label or serial number key.
+// Labels are matched first. k.mu must be held.
+func (k *Keyring) find(key string) int {
+	for i, e := range k.entries {
+		if e.label == key {
+			return i
+		}
+	}
+	if serial := normalizeSerial(key); serial != "" {
+		for i, e := range k.entries {
+			if normalizeSerial(e.otp.GetSerialNumber()) == serial {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// Add adds account with label. The account takes the offset of the last
+// SyncTime of the keyring, if any.
+func (k *Keyring) Add(label string, account *Account) (UOTP, error) {
+	if err := validLabel(label); err != nil {
+		return nil, err
+	}
+
+	o, err := New(account, k.opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return o, k.add(label, o.(*uotp))
+}
+
+func (k *Keyring) add(label string, u *uotp) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	serial := normalizeSerial(u.GetSerialNumber())
+	for _, e := range k.entries {
+		if e.label == label {
+			return fmt.Errorf("%w: %q", ErrDuplicateLabel, label)
+		}
+		if serial != "" && normalizeSerial(e.otp.GetSerialNumber()) == serial {
+			return fmt.Errorf("%w: %s", ErrDuplicateSerial, u.GetSerialNumber())
+		}
+	}
+
+	if k.sync != nil {
+		u.applySync(k.sync)
+	}
+	k.entries = append(k.entries, &keyringEntry{
+		label: label,
+		otp:   u,
+	})
+	return nil
+}
+
+// Issue issues a new account and adds it with label.
+func (k *Keyring) Issue(ctx context.Context, label string) (UOTP, error) {
+	if err := validLabel(label); err != nil {
+		return nil, err
+	}
+	if _, ok := k.Get(label); ok {
+		return nil, fmt.Errorf("%w: %q", ErrDuplicateLabel, label)
+	}
+
+	o, err := New(nil, k.opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err = o.Issue(ctx); err != nil {
+		return nil, err
+	}
+
+	return o, k.add(label, o.(*uotp))
+}
+
+// Get returns the account with the label or serial number key.
+func (k *Keyring) Get(key string) (UOTP, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	i := k.find(key)
+	if i < 0 {
+		return nil, false
+	}
+	return k.entries[i].otp, true
+}
+
+// Label returns the label of the account with the label or serial number key.
+func (k *Keyring) Label(key string) (string, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	i := k.find(key)
+	if i < 0 {
+		return "", false
+	}
+	return k.entries[i].label, true
+}
+
+// Remove removes the account with the label or serial number key.
+func (k *Keyring) Remove(key string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	i := k.find(key)
+	if i < 0 {
+		return fmt.Errorf("%w: %q", ErrAccountNotFound, key)
+	}
+	k.entries = append(k.entries[:i], k.entries[i+1:]...)
+	return nil
+}
+
+// Rename changes the label of the account with the label or serial number key.
+func (k *Keyring) Rename(key, label string) error {
+	if err := validLabel(label); err != nil {
+		return err
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	i := k.find(key)
+	if i < 0 {
+		return fmt.Errorf("%w: %q", ErrAccountNotFound, key)
+	}
+	for j, e := range k.entries {
+		if j != i && e.label == label {
+			return fmt.Errorf("%w: %q", ErrDuplicateLabel, label)
+		}
+	}
+	k.entries[i].label = label
+	return nil
+}
+
+// Len returns the number of accounts.
+func (k *Keyring) Len() int {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	return len(k.entries)
+}
+
+// Labels returns the labels of the accounts, in the order they were added.
+func (k *Keyring) Labels() []string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	labels := make([]string, len(k.entries))
+	for i, e := range k.entries {
+		labels[i] = e.label
+	}
+	return labels
+}
+
+// KeyringToken is a token generated by Keyring.Tokens.
+type KeyringToken struct {
+	Label        string `json:"label"`
+	SerialNumber string `json:"serial_number"`
+	Token        string `json:"token"`
+}
+
+// Tokens generates a token for every account, in the order they were added.
+func (k *Keyring) Tokens() []KeyringToken {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	tokens := make([]KeyringToken, len(k.entries))
+	for i, e := range k.entries {
+		tokens[i] = KeyringToken{
+			Label:        e.label,
+			SerialNumber: e.otp.GetSerialNumber(),
+			Token:        e.otp.GenerateToken(),
+		}
+	}
+	return tokens
+}
+
+// SyncedAt returns when the oldest offset of the accounts was measured, or
+// the zero time when one of them was never synchronized.
+func (k *Keyring) SyncedAt() time.Time {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	var oldest time.Time
+	for i, e := range k.entries {
+		e.otp.mu.RLock()
+		at := e.otp.timeSyncedAt
+		e.otp.mu.RUnlock()
+
+		if at.IsZero() {
+			return time.Time{}
+		}
+		if i == 0 || at.Before(oldest) {
+			oldest = at
+		}
+	}
+	return oldest
+}
+
+// SyncTime synchronizes the time once and uses the offset for every account.
+func (k *Keyring) SyncTime(ctx context.Context) (*SyncResult, error) {
+	// The offset does not depend on the account.
+	o, err := New(nil, k.opts...)
+	if err != nil {
+		return nil, err
+	}
+	r, err := o.SyncTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.sync = r
+	for _, e := range k.entries {
+		e.otp.applySync(r)
+	}
+	return r, nil
+}
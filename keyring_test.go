@@ -0,0 +1,165 @@
+package uotp
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestKeyring(t *testing.T) {
+	k := NewKeyring(WithClient(&fakeServer{t: t}))
+
+	work := testAccount
+	if _, err := k.Add("work", &work); err != nil {
+		t.Fatal(err)
+	}
+	again := testAccount
+	again.SerialNumber = "1111-2222-3333"
+	if _, err := k.Add("work", &again); !errors.Is(err, ErrDuplicateLabel) {
+		t.Errorf("duplicate label: %v", err)
+	}
+	dup := testAccount
+	if _, err := k.Add("other", &dup); !errors.Is(err, ErrDuplicateSerial) {
+		t.Errorf("duplicate serial: %v", err)
+	}
+	if _, err := k.Add(" ", &Account{}); !errors.Is(err, ErrInvalidLabel) {
+		t.Errorf("invalid label: %v", err)
+	}
+
+	for _, key := range []string{"work", "1784-5365-6261", "178453656261"} {
+		if o, ok := k.Get(key); !ok || o.GetSerialNumber() != testAccount.SerialNumber {
+			t.Errorf("Get(%q) = %v, %v", key, o, ok)
+		}
+	}
+	if _, ok := k.Get("home"); ok {
+		t.Error("found an unknown label")
+	}
+
+	if err := k.Rename("1784-5365-6261", "office"); err != nil {
+		t.Fatal(err)
+	}
+	if label, _ := k.Label("178453656261"); label != "office" {
+		t.Errorf("renamed to %q", label)
+	}
+
+	tokens := k.Tokens()
+	if len(tokens) != 1 || tokens[0].Label != "office" || tokens[0].Token == "" {
+		t.Errorf("tokens: %+v", tokens)
+	}
+
+	if err := k.Remove("office"); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.Remove("office"); !errors.Is(err, ErrAccountNotFound) {
+		t.Errorf("removed twice: %v", err)
+	}
+	if k.Len() != 0 {
+		t.Errorf("%d accounts left", k.Len())
+	}
+}
+
+func TestKeyringSharedSync(t *testing.T) {
+	offset := 90 * time.Minute
+	k := NewKeyring(WithClient(&fakeServer{t: t, err: errOffline}), WithTimeSources(NewFixedTimeSource(time.Now().Add(offset))))
+
+	first := testAccount
+	a, err := k.Add("first", &first)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if at := k.SyncedAt(); !at.IsZero() {
+		t.Errorf("synced at %s before SyncTime", at)
+	}
+
+	r, err := k.SyncTime(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := a.GetAccount().TimeOffset; got != r.Offset {
+		t.Errorf("first account has offset %s, want %s", got, r.Offset)
+	}
+	if at := k.SyncedAt(); !at.Equal(r.At) {
+		t.Errorf("synced at %s, want %s", at, r.At)
+	}
+
+	// Accounts added later take the offset too.
+	second := testAccount
+	second.SerialNumber = "1111-2222-3333"
+	b, err := k.Add("second", &second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := b.GetAccount().TimeOffset; got != r.Offset {
+		t.Errorf("second account has offset %s, want %s", got, r.Offset)
+	}
+
+	// An account that was never synchronized makes the keyring stale.
+	third := testAccount
+	third.SerialNumber = "4444-5555-6666"
+	k.sync = nil
+	if _, err = k.Add("third", &third); err != nil {
+		t.Fatal(err)
+	}
+	if at := k.SyncedAt(); !at.IsZero() {
+		t.Errorf("synced at %s with an account never synchronized", at)
+	}
+}
+
+func TestKeyringSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keyring.json")
+
+	k, err := LoadKeyring(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k.Len() != 0 {
+		t.Fatalf("missing file has %d accounts", k.Len())
+	}
+
+	k = NewKeyring(WithClient(&fakeServer{t: t}))
+	if _, err = k.Issue(context.Background(), "issued"); err != nil {
+		t.Fatal(err)
+	}
+	other := testAccount
+	other.SerialNumber = "1111-2222-3333"
+	if _, err = k.Add("other", &other); err != nil {
+		t.Fatal(err)
+	}
+	if err = k.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadKeyring(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if labels := loaded.Labels(); len(labels) != 2 || labels[0] != "issued" || labels[1] != "other" {
+		t.Fatalf("labels: %v", labels)
+	}
+	want, _ := k.Get("other")
+	got, _ := loaded.Get("other")
+	if got.GetAccount().Seed != want.GetAccount().Seed || got.GenerateToken() != want.GenerateToken() {
+		t.Error("loaded account differs")
+	}
+}
+
+func TestKeyringSyncManager(t *testing.T) {
+	k := NewKeyring(WithClient(&fakeServer{t: t, err: errOffline}), WithTimeSources(NewFixedTimeSource(time.Now().Add(time.Hour))))
+	account := testAccount
+	a, err := k.Add("first", &account)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &SyncManager{Syncer: k}
+	r, err := m.Sync(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := a.GetAccount().TimeOffset; got != r.Offset || m.Health().Source != "fixed" {
+		t.Errorf("account has offset %s, want %s", got, r.Offset)
+	}
+}
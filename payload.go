@@ -1,34 +1,40 @@
 package uotp
 
 import (
-	"errors"
-	"io"
-)
-
-type status string
-
-const (
-	statusOK status = "0000"
-)
-
-type opCode int
+	"fmt"
 
-const (
-	opCodeError           opCode = -1
-	opCodeInformation     opCode = 402
-	opCodeTime            opCode = 407
-	opCodeIssue           opCode = 451
-	opCodeResetErrorCount opCode = 452
-	opCodeUseHistory      opCode = 453
-	opCodeHelp            opCode = 454
+	"github.com/RyuaNerin/uotp/protocol"
 )
 
 type payload interface {
-	opcode() opCode
+	opcode() protocol.OpCode
 	needsCommonHeader() bool
-	initPacket(p *packet)
-	encode(w io.Writer)
+	encode(dst []byte) ([]byte, error)
 	decode(payload []byte) error
 }
 
-var ErrInvalidPacket = errors.New("invalid packet")
+func newPayload(opcode protocol.OpCode) payload {
+	switch opcode {
+	case protocol.OpTime:
+		return new(payloadTime)
+	case protocol.OpIssue:
+		return new(payloadIssue)
+	case protocol.OpResetErrorCount:
+		return new(payloadResetErrorCount)
+	case protocol.OpInformation:
+		return new(payloadInfomation)
+	case protocol.OpUseHistory:
+		return new(History)
+	case protocol.OpHelp:
+		return new(payloadHelp)
+	}
+	return nil
+}
+
+// ErrInvalidPacket is returned when a response cannot be decoded.
+var ErrInvalidPacket = protocol.ErrInvalidPacket
+
+// invalidPacket wraps ErrInvalidPacket with a description of what was wrong.
+func invalidPacket(format string, a ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrInvalidPacket, fmt.Sprintf(format, a...))
+}
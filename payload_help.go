@@ -1,31 +1,39 @@
 package uotp
 
 import (
-	"io"
 	"strings"
+
+	"github.com/RyuaNerin/uotp/internal/record"
+	"github.com/RyuaNerin/uotp/protocol"
 )
 
+var payloadHelpLayout = record.Layout{
+	{Name: "messages", Kind: record.EUCKR, Width: record.Variable},
+	{Name: "trailer", Kind: record.ASCII, Width: 8},
+}
+
 type payloadHelp struct {
 	messages []string
 }
 
-func (p *payloadHelp) opcode() opCode {
-	return opCodeHelp
+func (p *payloadHelp) opcode() protocol.OpCode {
+	return protocol.OpHelp
 }
 func (p *payloadHelp) needsCommonHeader() bool {
 	return true
 }
-func (p *payloadHelp) initPacket(pk *packet) {
-}
-func (p *payloadHelp) encode(w io.Writer) {
+func (p *payloadHelp) encode(dst []byte) ([]byte, error) {
+	return dst, nil
 }
 func (p *payloadHelp) decode(payload []byte) error {
-	if len(payload) < 8 {
-		return ErrInvalidPacket
+	d := record.NewDecoder(payloadHelpLayout, payload)
+	messages := d.EUCKR()
+	d.Skip()
+	if err := d.Err(); err != nil {
+		return invalidPacket("help: %v", err)
 	}
 
-	payload = payload[:len(payload)-8]
-	p.messages = strings.Split(decodeEUCKR(payload), "|")
+	p.messages = strings.Split(messages, "|")
 
 	return nil
 }
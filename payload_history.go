@@ -1,16 +1,34 @@
 package uotp
 
 import (
-	"fmt"
-	"io"
-	"strconv"
-	"strings"
 	"time"
+
+	"github.com/RyuaNerin/uotp/internal/record"
+	"github.com/RyuaNerin/uotp/protocol"
+)
+
+var (
+	historyRequestLayout = record.Layout{
+		{Name: "page", Kind: record.Numeric, Width: 4},
+		{Name: "period", Kind: record.Numeric, Width: 1},
+	}
+	historyLayout = record.Layout{
+		{Name: "period_start", Kind: record.ASCII, Width: 10},
+		{Name: "period_end", Kind: record.ASCII, Width: 10},
+		{Name: "page_total", Kind: record.Numeric, Width: 4},
+		{Name: "page_current", Kind: record.Numeric, Width: 4},
+		{Name: "count", Kind: record.Numeric, Width: 2},
+	}
+	historyEntryLayout = record.Layout{
+		{Name: "at", Kind: record.ASCII, Width: 18},
+		{Name: "type", Kind: record.EUCKR, Width: 40},
+		{Name: "name", Kind: record.EUCKR, Width: 40},
+	}
 )
 
 type History struct {
 	requestPage   int
-	requestPeriod int
+	requestPeriod HistoryPeriod
 
 	PeriodStart time.Time
 	PeriodEnd   time.Time
@@ -21,61 +39,67 @@ type History struct {
 }
 
 type HistoryEntry struct {
-	At   time.Time
-	Type string
-	Name string
+	At   time.Time `json:"at"`
+	Type string    `json:"type"`
+	Name string    `json:"name"`
 }
 
-func (p *History) opcode() opCode {
-	return opCodeUseHistory
+func (p *History) opcode() protocol.OpCode {
+	return protocol.OpUseHistory
 }
 func (p *History) needsCommonHeader() bool {
 	return true
 }
-func (p *History) initPacket(pk *packet) {
-}
-func (p *History) encode(w io.Writer) {
-	fmt.Fprintf(w, "%04d%1d", p.requestPage, p.requestPeriod)
+func (p *History) encode(dst []byte) ([]byte, error) {
+	e := record.NewEncoder(historyRequestLayout, dst)
+	e.Uint(uint64(p.requestPage))
+	e.Uint(uint64(p.requestPeriod))
+	return e.Bytes(), e.Err()
 }
 func (p *History) decode(payload []byte) (err error) {
-	p.PeriodStart, err = time.ParseInLocation("2006-01-02", string(payload[0:10]), time.Local)
-	if err != nil {
-		return ErrInvalidPacket
+	d := record.NewDecoder(historyLayout, payload)
+	periodStart := d.Bytes()
+	periodEnd := d.Bytes()
+	pageTotal := d.Int()
+	pageCurrent := d.Int()
+	dataCount := d.Int()
+	if err := d.Err(); err != nil {
+		return invalidPacket("history: %v", err)
 	}
 
-	p.PeriodEnd, err = time.ParseInLocation("2006-01-02", string(payload[10:10+10]), time.Local)
+	p.PeriodStart, err = time.ParseInLocation("2006-01-02", b2s(periodStart), serverLocation)
 	if err != nil {
-		return ErrInvalidPacket
+		return invalidPacket("history period start %q", periodStart)
 	}
 
-	p.PageTotal, err = strconv.Atoi(string(payload[20 : 20+4]))
+	p.PeriodEnd, err = time.ParseInLocation("2006-01-02", b2s(periodEnd), serverLocation)
 	if err != nil {
-		return ErrInvalidPacket
+		return invalidPacket("history period end %q", periodEnd)
 	}
 
-	p.PageCurrent, err = strconv.Atoi(string(payload[20+4 : 20+4+4]))
-	if err != nil {
-		return ErrInvalidPacket
-	}
+	p.PageTotal = pageTotal
+	p.PageCurrent = pageCurrent
 
-	dataCount, err := strconv.Atoi(string(payload[20+4+4 : 20+4+4+2]))
-	if err != nil {
-		return ErrInvalidPacket
-	}
-	if len(payload) < 30+(18+40+40)*dataCount {
-		return ErrInvalidPacket
+	payload = d.Rest()
+	entrySize := historyEntryLayout.Size()
+	if len(payload) < entrySize*dataCount {
+		return invalidPacket("history with %d entries needs %d bytes, got %d", dataCount, entrySize*dataCount, len(payload))
 	}
 
-	offset := 30
 	p.Entries = make([]HistoryEntry, 0, dataCount)
-	for i := 0; i < int(dataCount); i++ {
-		date, err := time.ParseInLocation("2006-01-0215:04:05", b2s(payload[offset:offset+18]), time.Local)
-		if err != nil {
-			return ErrInvalidPacket
+	for i := 0; i < dataCount; i++ {
+		d := record.NewDecoder(historyEntryLayout, payload[i*entrySize:])
+		at := d.Bytes()
+		type_ := d.EUCKR()
+		name := d.EUCKR()
+		if err := d.Err(); err != nil {
+			return invalidPacket("history entry %d: %v", i, err)
 		}
 
-		type_ := strings.TrimSpace(decodeEUCKR(payload[offset+18 : offset+18+40]))
-		name := strings.TrimSpace(decodeEUCKR(payload[offset+18+40 : offset+18+40+40]))
+		date, err := time.ParseInLocation("2006-01-0215:04:05", b2s(at), serverLocation)
+		if err != nil {
+			return invalidPacket("history entry %d date %q", i, at)
+		}
 
 		p.Entries = append(
 			p.Entries,
@@ -85,7 +109,6 @@ func (p *History) decode(payload []byte) (err error) {
 				Name: name,
 			},
 		)
-		offset += 18 + 40 + 40
 	}
 
 	return nil
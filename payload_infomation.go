@@ -1,40 +1,53 @@
 package uotp
 
 import (
-	"io"
-	"strconv"
-	"strings"
+	"github.com/RyuaNerin/uotp/internal/record"
+	"github.com/RyuaNerin/uotp/protocol"
 )
 
+var payloadInfomationLayout = record.Layout{
+	{Name: "oid", Kind: record.Numeric, Width: 11},
+	{Name: "seed", Kind: record.ASCII, Width: 40},
+	{Name: "partner", Kind: record.ASCII, Width: 80},
+}
+
+// Information is what the server knows about an account.
+type Information struct {
+	OID int
+	// Partner is the service the account was issued through.
+	Partner string
+}
+
 type payloadInfomation struct {
 	oid     int
 	seeed   string
 	partner string
 }
 
-func (p *payloadInfomation) opcode() opCode {
-	return opCodeInformation
+func (p *payloadInfomation) opcode() protocol.OpCode {
+	return protocol.OpInformation
 }
 func (p *payloadInfomation) needsCommonHeader() bool {
 	return true
 }
-func (p *payloadInfomation) initPacket(pk *packet) {
-}
-func (p *payloadInfomation) encode(w io.Writer) {
+func (p *payloadInfomation) encode(dst []byte) ([]byte, error) {
+	return dst, nil
 }
 func (p *payloadInfomation) decode(payload []byte) error {
-	if len(payload) < 11+40+80 {
-		return ErrInvalidPacket
+	d := record.NewDecoder(payloadInfomationLayout, payload)
+	oid := d.Uint()
+	seed := d.String()
+	partner := d.String()
+	if err := d.Err(); err != nil {
+		return invalidPacket("information: %v", err)
 	}
-
-	oid, err := strconv.ParseInt(string(payload[:11]), 10, 32)
-	if err != nil {
-		return ErrInvalidPacket
+	if oid > 1<<31-1 {
+		return invalidPacket("information: oid %d overflows int32", oid)
 	}
 
 	p.oid = int(oid)
-	p.seeed = strings.TrimSpace(string(payload[11 : 11+40]))
-	p.partner = strings.TrimSpace(string(payload[11+40 : 11+40+80]))
+	p.seeed = seed
+	p.partner = partner
 
 	return nil
 }
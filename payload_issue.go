@@ -1,12 +1,18 @@
 package uotp
 
 import (
-	"encoding/hex"
-	"io"
-	"strconv"
-	"strings"
+	"github.com/RyuaNerin/uotp/internal/record"
+	"github.com/RyuaNerin/uotp/protocol"
 )
 
+var payloadIssueLayout = record.Layout{
+	{Name: "serial_number", Kind: record.ASCII, Width: 20},
+	{Name: "oid", Kind: record.Numeric, Width: 11},
+	{Name: "seed", Kind: record.Hex, Width: 40},
+	{Name: "user_hash", Kind: record.ASCII, Width: 64},
+	{Name: "issue_info", Kind: record.ASCII, Width: 80},
+}
+
 type payloadIssue struct {
 	oid          uint64
 	seed         []byte
@@ -15,38 +21,36 @@ type payloadIssue struct {
 	issueInfo    string
 }
 
-func (p *payloadIssue) opcode() opCode {
-	return opCodeIssue
+func (p *payloadIssue) opcode() protocol.OpCode {
+	return protocol.OpIssue
 }
 func (p *payloadIssue) needsCommonHeader() bool {
 	return true
 }
-func (p *payloadIssue) initPacket(pk *packet) {
-	pk.setEncryptionInfo(newSharedKey(), "")
-}
-func (p *payloadIssue) encode(w io.Writer) {
+func (p *payloadIssue) encode(dst []byte) ([]byte, error) {
+	return dst, nil
 }
 func (p *payloadIssue) decode(payload []byte) error {
-	if len(payload) < 20+11+40+64+80 {
-		return ErrInvalidPacket
-	}
+	seed := make([]byte, 20)
 
-	oid, err := strconv.ParseUint(string(payload[20:20+11]), 10, 64)
-	if err != nil {
-		return ErrInvalidPacket
+	d := record.NewDecoder(payloadIssueLayout, payload)
+	serialNumber := d.String()
+	oid := d.Uint()
+	n := d.Hex(seed)
+	userHash := d.String()
+	issueInfo := d.String()
+	if err := d.Err(); err != nil {
+		return invalidPacket("issue: %v", err)
 	}
-
-	seed := make([]byte, 20)
-	_, err = hex.Decode(seed, payload[20+11:20+11+40])
-	if err != nil {
-		return ErrInvalidPacket
+	if n != len(seed) {
+		return invalidPacket("issue: seed of %d bytes, want %d", n, len(seed))
 	}
 
-	p.serialNumber = strings.TrimSpace(string(payload[0:20]))
-	p.oid = uint64(oid)
+	p.serialNumber = serialNumber
+	p.oid = oid
 	p.seed = seed
-	p.userHash = string(payload[20+11+40 : 20+11+40+64])
-	p.issueInfo = strings.TrimSpace(string(payload[20+11+40+64 : 20+11+40+64+80]))
+	p.userHash = userHash
+	p.issueInfo = issueInfo
 
 	return nil
 }
@@ -1,19 +1,18 @@
 package uotp
 
-import "io"
+import "github.com/RyuaNerin/uotp/protocol"
 
 type payloadResetErrorCount struct {
 }
 
-func (p *payloadResetErrorCount) opcode() opCode {
-	return opCodeResetErrorCount
+func (p *payloadResetErrorCount) opcode() protocol.OpCode {
+	return protocol.OpResetErrorCount
 }
 func (p *payloadResetErrorCount) needsCommonHeader() bool {
 	return true
 }
-func (p *payloadResetErrorCount) initPacket(pk *packet) {
-}
-func (p *payloadResetErrorCount) encode(w io.Writer) {
+func (p *payloadResetErrorCount) encode(dst []byte) ([]byte, error) {
+	return dst, nil
 }
 func (p *payloadResetErrorCount) decode(payload []byte) error {
 	return nil
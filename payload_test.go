@@ -0,0 +1,237 @@
+package uotp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/RyuaNerin/uotp/internal/record"
+	"github.com/RyuaNerin/uotp/protocol"
+)
+
+func TestPayloadIssueRoundTrip(t *testing.T) {
+	want := payloadIssue{
+		oid:          630981781,
+		seed:         []byte{0xba, 0x90, 0x04, 0xad, 0x3f, 0xfc, 0xce, 0x34, 0x98, 0x80, 0x8a, 0xb6, 0xa6, 0x5e, 0x4c, 0x8a, 0x8a, 0xae, 0xf6, 0xbb},
+		serialNumber: "178453656261",
+		userHash:     "99eb850e6bd360717a98bbb72b8b30220603995d2b02aa837fcbf5b0460d7614",
+		issueInfo:    "info",
+	}
+
+	e := record.NewEncoder(payloadIssueLayout, nil)
+	e.String(want.serialNumber)
+	e.Uint(want.oid)
+	e.Hex(want.seed)
+	e.String(want.userHash)
+	e.String(want.issueInfo)
+	if err := e.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	var got payloadIssue
+	if err := got.decode(e.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("decoded %+v, want %+v", got, want)
+	}
+
+	// A short seed would be padded with zeros and give wrong tokens.
+	e = record.NewEncoder(payloadIssueLayout, nil)
+	e.String(want.serialNumber)
+	e.Uint(want.oid)
+	e.Hex(want.seed[:19])
+	e.String(want.userHash)
+	e.String(want.issueInfo)
+	if err := e.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if err := new(payloadIssue).decode(e.Bytes()); !errors.Is(err, ErrInvalidPacket) {
+		t.Errorf("decoded a short seed: %v", err)
+	}
+}
+
+func TestPayloadInfomationRoundTrip(t *testing.T) {
+	want := payloadInfomation{
+		oid:     630981781,
+		seeed:   "ba9004ad3ffcce3498808ab6a65e4c8a8aaef6bb",
+		partner: "partner",
+	}
+
+	e := record.NewEncoder(payloadInfomationLayout, nil)
+	e.Uint(uint64(want.oid))
+	e.String(want.seeed)
+	e.String(want.partner)
+	if err := e.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	var got payloadInfomation
+	if err := got.decode(e.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("decoded %+v, want %+v", got, want)
+	}
+}
+
+func TestGetInformation(t *testing.T) {
+	u := newTestUOTP(t, &fakeServer{t: t})
+
+	info, err := u.GetInformation(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.OID != 630981781 || info.Partner != "partner" {
+		t.Errorf("got %+v", info)
+	}
+}
+
+func TestHistoryRoundTrip(t *testing.T) {
+	want := History{
+		PeriodStart: time.Date(2022, 1, 1, 0, 0, 0, 0, serverLocation),
+		PeriodEnd:   time.Date(2022, 3, 31, 0, 0, 0, 0, serverLocation),
+		PageCurrent: 1,
+		PageTotal:   2,
+		Entries: []HistoryEntry{
+			{At: time.Date(2022, 3, 1, 12, 34, 56, 0, serverLocation), Type: "인증", Name: "서비스"},
+			{At: time.Date(2022, 3, 2, 1, 2, 3, 0, serverLocation), Type: "오류 초기화", Name: "OTP"},
+		},
+	}
+
+	e := record.NewEncoder(historyLayout, nil)
+	e.String(want.PeriodStart.Format("2006-01-02"))
+	e.String(want.PeriodEnd.Format("2006-01-02"))
+	e.Uint(uint64(want.PageTotal))
+	e.Uint(uint64(want.PageCurrent))
+	e.Uint(uint64(len(want.Entries)))
+	data := e.Bytes()
+	if err := e.Err(); err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range want.Entries {
+		e := record.NewEncoder(historyEntryLayout, data)
+		e.String(entry.At.Format("2006-01-0215:04:05"))
+		e.EUCKR(entry.Type)
+		e.EUCKR(entry.Name)
+		data = e.Bytes()
+		if err := e.Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var got History
+	if err := got.decode(data); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("decoded %+v, want %+v", got, want)
+	}
+}
+
+// TestHistoryServerTime checks that the times of the server are read in its
+// zone, whatever the zone of the host.
+func TestHistoryServerTime(t *testing.T) {
+	defer func(local *time.Location) { time.Local = local }(time.Local)
+	time.Local = time.FixedZone("EST", -5*60*60)
+
+	e := record.NewEncoder(historyLayout, nil)
+	e.String("2022-01-01")
+	e.String("2022-03-31")
+	e.Uint(1)
+	e.Uint(1)
+	e.Uint(1)
+	e = record.NewEncoder(historyEntryLayout, e.Bytes())
+	e.String("2022-03-0112:34:56")
+	e.EUCKR("인증")
+	e.EUCKR("서비스")
+	if err := e.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	var got History
+	if err := got.decode(e.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2022, 3, 1, 3, 34, 56, 0, time.UTC); !got.Entries[0].At.Equal(want) {
+		t.Errorf("entry at %v, want %v", got.Entries[0].At, want)
+	}
+	if want := time.Date(2021, 12, 31, 15, 0, 0, 0, time.UTC); !got.PeriodStart.Equal(want) {
+		t.Errorf("period start %v, want %v", got.PeriodStart, want)
+	}
+}
+
+func TestHistoryRequestRoundTrip(t *testing.T) {
+	p := History{requestPage: 12, requestPeriod: HistoryPeriodThreeMonths}
+
+	data, err := p.encode(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "00123" {
+		t.Fatalf("encoded %q", data)
+	}
+
+	d := record.NewDecoder(historyRequestLayout, data)
+	page := d.Int()
+	period := d.Int()
+	if err := d.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if page != p.requestPage || HistoryPeriod(period) != p.requestPeriod {
+		t.Errorf("decoded %d %d", page, period)
+	}
+}
+
+func TestPayloadHelpRoundTrip(t *testing.T) {
+	want := []string{"첫 번째", "두 번째"}
+
+	e := record.NewEncoder(payloadHelpLayout, nil)
+	e.EUCKR("첫 번째|두 번째")
+	e.String("00000000")
+	if err := e.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	var got payloadHelp
+	if err := got.decode(e.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.messages, want) {
+		t.Errorf("decoded %q, want %q", got.messages, want)
+	}
+}
+
+func TestCommonHeaderRoundTrip(t *testing.T) {
+	want := DeviceProfile{Carrier: "SKT", Model: "SM-G950S", AppVersion: "GA15", OSType: 2, OSVersion: 0}
+
+	p := newPacket(protocol.OpUseHistory)
+	p.oid = 630981781
+	p.device = &want
+
+	data, err := p.appendCommonHeader(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, []byte("SKT630981781  SM-G950S")) {
+		t.Fatalf("encoded %q", data)
+	}
+
+	var got DeviceProfile
+	d := record.NewDecoder(commonHeaderLayout, data)
+	got.Carrier = d.String()
+	oid := d.Uint()
+	got.Model = d.String()
+	got.AppVersion = d.String()
+	got.OSType = d.Int()
+	got.OSVersion = d.Int()
+	if err := d.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if got != want || oid != p.oid {
+		t.Errorf("decoded %+v %d, want %+v %d", got, oid, want, p.oid)
+	}
+}
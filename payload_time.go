@@ -2,26 +2,26 @@ package uotp
 
 import (
 	"encoding/binary"
-	"io"
+
+	"github.com/RyuaNerin/uotp/protocol"
 )
 
 type payloadTime struct {
 	Time int
 }
 
-func (p *payloadTime) opcode() opCode {
-	return opCodeTime
+func (p *payloadTime) opcode() protocol.OpCode {
+	return protocol.OpTime
 }
 func (p *payloadTime) needsCommonHeader() bool {
 	return false
 }
-func (p *payloadTime) initPacket(pk *packet) {
-}
-func (p *payloadTime) encode(w io.Writer) {
+func (p *payloadTime) encode(dst []byte) ([]byte, error) {
+	return dst, nil
 }
 func (p *payloadTime) decode(payload []byte) error {
 	if len(payload) < 4 {
-		return ErrInvalidPacket
+		return invalidPacket("time needs 4 bytes, got %d", len(payload))
 	}
 	p.Time = int(binary.BigEndian.Uint32(payload))
 	return nil
@@ -0,0 +1,106 @@
+package protocol
+
+import (
+	"context"
+	"net"
+)
+
+// DefaultAddr is the address of the μOTP+ server.
+const DefaultAddr = "211.49.97.230:20004"
+
+// DefaultClient is the client used by Do.
+var DefaultClient = &Client{}
+
+// Client sends requests to a μOTP+ server, one connection per request.
+type Client struct {
+	// Addr is the server address. If empty, DefaultAddr is used.
+	Addr string
+	// Dialer is used to connect. If nil, a zero net.Dialer is used.
+	Dialer *net.Dialer
+	// Padding selects how responses are unpadded.
+	Padding PaddingMode
+	// ConstantTime selects the SEED implementation without lookup tables.
+	ConstantTime bool
+}
+
+func (c *Client) cipherOptions() cipherOptions {
+	return cipherOptions{
+		padding:      c.Padding,
+		constantTime: c.ConstantTime,
+	}
+}
+
+// Do sends payload with op using DefaultClient and returns the response.
+func Do(ctx context.Context, op OpCode, payload []byte, enc *Encryption) (*Frame, error) {
+	return DefaultClient.Do(ctx, op, payload, enc)
+}
+
+// Do sends payload with op and returns the response.
+//
+// If enc is not nil, the extra token is appended to the payload, the payload
+// is encrypted and the shared key is sent along. If enc is nil the payload
+// is sent as it is.
+func (c *Client) Do(ctx context.Context, op OpCode, payload []byte, enc *Encryption) (*Frame, error) {
+	req := &Frame{
+		Status:  StatusOK,
+		OpCode:  op,
+		Payload: payload,
+	}
+	if enc != nil {
+		req.SharedKey = enc.SharedKey
+		if len(enc.ExtraToken) != 0 {
+			req.Payload = make([]byte, 0, len(payload)+len(enc.ExtraToken))
+			req.Payload = append(req.Payload, payload...)
+			req.Payload = append(req.Payload, enc.ExtraToken...)
+		}
+	}
+
+	key, err := enc.Key()
+	if err != nil {
+		return nil, err
+	}
+	buf, err := encodeFrame(req, key, c.cipherOptions())
+	if err != nil {
+		return nil, err
+	}
+
+	addr := c.Addr
+	if addr == "" {
+		addr = DefaultAddr
+	}
+	dialer := c.Dialer
+	if dialer == nil {
+		dialer = &net.Dialer{}
+	}
+
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if trace := ContextClientTrace(ctx); trace != nil && trace.Connected != nil {
+		trace.Connected()
+	}
+
+	_, err = conn.Write(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ReadFrame(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	err = conn.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeFrame(data, key, c.cipherOptions())
+}
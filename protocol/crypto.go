@@ -0,0 +1,215 @@
+package protocol
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/RyuaNerin/uotp/seed"
+)
+
+func populateKeyAndIV(key []byte, iv []byte, sharedKey []byte) {
+	_ = key[15]
+	_ = iv[15]
+
+	h := sha1.New()
+
+	h.Write(sharedKey)
+	r := h.Sum(nil)
+
+	for i := 0; i < 4; i++ {
+		h.Reset()
+		h.Write(r)
+		r = h.Sum(r[:0])
+	}
+
+	h.Reset()
+	h.Write(r[16:])
+	copy(iv, h.Sum(nil)[:16])
+	copy(key, r[:16])
+}
+
+// cipherOptions selects how payloads are encrypted and decrypted.
+type cipherOptions struct {
+	padding      PaddingMode
+	constantTime bool
+}
+
+func (o cipherOptions) newCipher(key []byte) (cipher.Block, error) {
+	if o.constantTime {
+		return seed.NewCipher(key, seed.ConstantTime())
+	}
+	return seed.NewCipher(key)
+}
+
+func encrypt(sharedKey []byte, src []byte) ([]byte, error) {
+	return encryptWith(sharedKey, src, cipherOptions{})
+}
+
+func encryptWith(sharedKey []byte, src []byte, opts cipherOptions) ([]byte, error) {
+	var key, iv [16]byte
+	populateKeyAndIV(key[:], iv[:], sharedKey)
+
+	b, err := opts.newCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+
+	padSize := 16 - (len(src) % 16)
+	srcPadded := make([]byte, len(src)+padSize)
+	copy(srcPadded, src)
+	for i := len(srcPadded) - padSize; i < len(srcPadded); i++ {
+		srcPadded[i] = byte(padSize)
+	}
+
+	c := cipher.NewCBCEncrypter(b, iv[:])
+
+	dst := make([]byte, len(srcPadded))
+	c.CryptBlocks(dst, srcPadded)
+
+	return dst, nil
+}
+
+// PaddingMode selects how the padding of a decrypted payload is checked.
+type PaddingMode int
+
+const (
+	// PaddingStrict rejects a payload without valid PKCS#7 padding.
+	PaddingStrict PaddingMode = iota
+	// PaddingLegacy strips padding of 1 to 15 bytes when it is valid and
+	// keeps the payload as it is otherwise, like earlier versions did.
+	PaddingLegacy
+)
+
+// ErrInvalidPadding is returned when a decrypted payload is not padded
+// correctly.
+var ErrInvalidPadding = errors.New("invalid padding")
+
+func decrypt(sharedKey []byte, src []byte) ([]byte, error) {
+	return decryptWith(sharedKey, src, cipherOptions{})
+}
+
+func decryptWith(sharedKey []byte, src []byte, opts cipherOptions) ([]byte, error) {
+	if len(src) == 0 || len(src)%seed.BlockSize != 0 {
+		return nil, fmt.Errorf("ciphertext length %d is not a multiple of %d", len(src), seed.BlockSize)
+	}
+
+	var key, iv [16]byte
+	populateKeyAndIV(key[:], iv[:], sharedKey)
+
+	b, err := opts.newCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+
+	c := cipher.NewCBCDecrypter(b, iv[:])
+
+	dst := make([]byte, len(src))
+	c.CryptBlocks(dst, src)
+
+	if opts.padding == PaddingLegacy {
+		return unpadLegacy(dst), nil
+	}
+	return unpad(dst, seed.BlockSize)
+}
+
+// unpad removes PKCS#7 padding. The time taken does not depend on the
+// value of the padding. len(b) must be a non-zero multiple of blockSize.
+func unpad(b []byte, blockSize int) ([]byte, error) {
+	pad := b[len(b)-1]
+
+	good := subtle.ConstantTimeLessOrEq(1, int(pad)) & subtle.ConstantTimeLessOrEq(int(pad), blockSize)
+	for i := 1; i <= blockSize; i++ {
+		// Bytes before the padding are not checked.
+		inPad := subtle.ConstantTimeLessOrEq(i, int(pad))
+		eq := subtle.ConstantTimeByteEq(b[len(b)-i], pad)
+		good &= eq | (inPad ^ 1)
+	}
+
+	if good != 1 {
+		return nil, ErrInvalidPadding
+	}
+	return b[:len(b)-int(pad)], nil
+}
+
+func unpadLegacy(dst []byte) []byte {
+	pad := int(dst[len(dst)-1])
+	if pad < 16 {
+		isPadded := true
+		for i := len(dst) - pad; i < len(dst); i++ {
+			if dst[i] != byte(pad) {
+				isPadded = false
+				break
+			}
+		}
+
+		if isPadded {
+			dst = dst[:len(dst)-pad]
+		}
+	}
+
+	return dst
+}
+
+// NewSharedKey returns a random shared key as hexadecimal text.
+func NewSharedKey() ([]byte, error) {
+	var b [32]byte
+	_, err := rand.Read(b[:])
+	if err != nil {
+		return nil, err
+	}
+
+	sharedKey := make([]byte, hex.EncodedLen(len(b)))
+	hex.Encode(sharedKey, b[:])
+	return sharedKey, nil
+}
+
+// Encryption holds what a request needs to be encrypted.
+type Encryption struct {
+	// SharedKey is sent in the clear, as hexadecimal text.
+	SharedKey []byte
+	// ExtraToken is appended to the payload before it is encrypted.
+	ExtraToken []byte
+}
+
+// NewEncryption returns an encryption context for sharedKey.
+// extraToken is usually the current OTP token, or empty if unused.
+func NewEncryption(sharedKey []byte, extraToken string) *Encryption {
+	e := &Encryption{
+		SharedKey: append([]byte(nil), sharedKey...),
+	}
+	if extraToken != "" {
+		e.ExtraToken = []byte(fmt.Sprintf("%07s ", extraToken))
+	}
+	return e
+}
+
+// Key returns the key used to encrypt the payload.
+// Without an extra token it is the shared key itself, otherwise it is
+// SHA-1 of the decoded shared key followed by the extra token.
+func (e *Encryption) Key() ([]byte, error) {
+	if e == nil || len(e.SharedKey) == 0 {
+		return nil, nil
+	}
+
+	if len(e.ExtraToken) == 0 {
+		r := make([]byte, len(e.SharedKey))
+		copy(r, e.SharedKey)
+		return r, nil
+	}
+
+	sharedKeyDecoded := make([]byte, hex.DecodedLen(len(e.SharedKey)))
+	_, err := hex.Decode(sharedKeyDecoded, e.SharedKey)
+	if err != nil {
+		return nil, fmt.Errorf("shared key: %w", err)
+	}
+
+	h := sha1.New()
+	h.Write(sharedKeyDecoded)
+	h.Write(e.ExtraToken)
+	return h.Sum(nil), nil
+}
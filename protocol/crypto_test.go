@@ -1,4 +1,4 @@
-package uotp
+package protocol
 
 import (
 	"bytes"
@@ -112,4 +112,111 @@ func TestDecryptWithSharedKey(t *testing.T) {
 	if !bytes.Equal(outDate, outputData) {
 		t.Errorf("outData is not matched\n%s", hex.Dump(outDate))
 	}
+
+	outDate, err = decryptWith(sharedKey, inputData, cipherOptions{constantTime: true})
+	if err != nil {
+		t.Errorf("%+v\n", err)
+		return
+	}
+	if !bytes.Equal(outDate, outputData) {
+		t.Errorf("outData is not matched with constant time SEED\n%s", hex.Dump(outDate))
+	}
+}
+
+func TestUnpad(t *testing.T) {
+	block := func(tail ...byte) []byte {
+		b := bytes.Repeat([]byte{'a'}, 16-len(tail))
+		return append(b, tail...)
+	}
+
+	valid := []struct {
+		src  []byte
+		want int
+	}{
+		{block(1), 15},
+		{block(2, 2), 14},
+		{block(0x09, 0x09, 0x09, 0x09, 0x09, 0x09, 0x09, 0x09, 0x09), 7},
+		{bytes.Repeat([]byte{16}, 16), 0},
+	}
+	for _, c := range valid {
+		dst, err := unpad(c.src, 16)
+		if err != nil {
+			t.Errorf("unpad(%x): %v", c.src, err)
+		} else if len(dst) != c.want {
+			t.Errorf("unpad(%x) = %d bytes, want %d", c.src, len(dst), c.want)
+		}
+	}
+
+	invalid := [][]byte{
+		block(0),
+		block(17),
+		block(1, 2),
+		block(3, 3),
+		block(0xff),
+	}
+	for _, src := range invalid {
+		if _, err := unpad(src, 16); err != ErrInvalidPadding {
+			t.Errorf("unpad(%x) = %v, want ErrInvalidPadding", src, err)
+		}
+	}
+}
+
+func TestDecryptPaddingModes(t *testing.T) {
+	sharedKey := []byte("0f1c7d6c75d313c4")
+
+	// 16 bytes of plaintext get a full block of padding. Drop it to get
+	// ciphertext without valid padding.
+	enc, err := encrypt(sharedKey, []byte("0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc = enc[:16]
+
+	if _, err := decryptWith(sharedKey, enc, cipherOptions{padding: PaddingStrict}); err != ErrInvalidPadding {
+		t.Errorf("strict: got %v, want ErrInvalidPadding", err)
+	}
+
+	dst, err := decryptWith(sharedKey, enc, cipherOptions{padding: PaddingLegacy})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(dst) != "0123456789abcdef" {
+		t.Errorf("legacy: got %q", dst)
+	}
+}
+
+func TestDecryptLength(t *testing.T) {
+	for _, n := range []int{0, 1, 15, 17} {
+		if _, err := decrypt([]byte("key"), make([]byte, n)); err == nil {
+			t.Errorf("expected an error for %d bytes of ciphertext", n)
+		}
+	}
+}
+
+func TestNewSharedKey(t *testing.T) {
+	a, err := NewSharedKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewSharedKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(a) != SharedKeySize {
+		t.Errorf("shared key is %d bytes, want %d", len(a), SharedKeySize)
+	}
+	if _, err := hex.DecodeString(string(a)); err != nil {
+		t.Errorf("shared key is not hex: %v", err)
+	}
+	if bytes.Equal(a, b) {
+		t.Error("two shared keys are equal")
+	}
+}
+
+func TestEncryptionKeyInvalidHex(t *testing.T) {
+	_, err := NewEncryption([]byte("not hex!"), "1234567").Key()
+	if err == nil {
+		t.Error("expected an error for a shared key that is not hex")
+	}
 }
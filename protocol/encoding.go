@@ -1,4 +1,4 @@
-package uotp
+package protocol
 
 import (
 	"golang.org/x/text/encoding/korean"
@@ -0,0 +1,192 @@
+package protocol
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	// HeaderSize is the size of the "S" marker and the 5-digit body length.
+	HeaderSize = 1 + 5
+	// MinBodySize is the size of the shared key, status and opcode fields.
+	MinBodySize = SharedKeySize + 4 + 3
+	// MaxBodySize is the largest length the 5-digit header can describe.
+	MaxBodySize = 99999
+	// SharedKeySize is the width of the shared key field.
+	SharedKeySize = 64
+)
+
+// Frame is one request or response.
+type Frame struct {
+	// SharedKey is sent in the clear, as hexadecimal text.
+	SharedKey []byte
+	Status    Status
+	OpCode    OpCode
+	// Payload is the plaintext payload.
+	Payload []byte
+}
+
+// EncodeFrame encodes f with its header. If key is not empty, the payload
+// is encrypted with it.
+func EncodeFrame(f *Frame, key []byte) ([]byte, error) {
+	return encodeFrame(f, key, cipherOptions{})
+}
+
+func encodeFrame(f *Frame, key []byte, opts cipherOptions) ([]byte, error) {
+	if len(f.SharedKey) > SharedKeySize {
+		return nil, fmt.Errorf("shared key is too long: %d bytes", len(f.SharedKey))
+	}
+	if len(f.Status) > 4 {
+		return nil, fmt.Errorf("status %q is too long", f.Status)
+	}
+	if f.OpCode < 0 || 999 < f.OpCode {
+		return nil, fmt.Errorf("opcode %d is out of range", f.OpCode)
+	}
+
+	payload := f.Payload
+	if len(key) != 0 && len(payload) != 0 {
+		var err error
+		payload, err = encryptWith(key, payload, opts)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	bodyLen := MinBodySize + len(payload)
+	if bodyLen > MaxBodySize {
+		return nil, fmt.Errorf("frame is too large: %d bytes", bodyLen)
+	}
+
+	data := make([]byte, 0, HeaderSize+bodyLen)
+	data = append(data, 'S')
+	data = appendPadded(data, strconv.Itoa(bodyLen), 5, '0')
+	data = appendPadded(data, string(f.SharedKey), SharedKeySize, ' ')
+	data = appendPadded(data, string(f.Status), 4, '0')
+	data = appendPadded(data, strconv.Itoa(int(f.OpCode)), 3, '0')
+	data = append(data, payload...)
+
+	return data, nil
+}
+
+// appendPadded appends s right-aligned in a field of width bytes.
+func appendPadded(dst []byte, s string, width int, pad byte) []byte {
+	for i := len(s); i < width; i++ {
+		dst = append(dst, pad)
+	}
+	return append(dst, s...)
+}
+
+// ReadFrame reads one frame, including its header.
+func ReadFrame(r io.Reader) ([]byte, error) {
+	var header [HeaderSize]byte
+	_, err := io.ReadFull(r, header[:])
+	if err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, invalidPacket("short header: %v", err)
+		}
+		return nil, err
+	}
+
+	bodyLen, err := parseHeader(header[:])
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, HeaderSize+bodyLen)
+	copy(data, header[:])
+	_, err = io.ReadFull(r, data[HeaderSize:])
+	if err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, invalidPacket("body shorter than %d bytes: %v", bodyLen, err)
+		}
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func parseHeader(header []byte) (int, error) {
+	if header[0] != 'S' {
+		return 0, invalidPacket("unexpected marker %q", header[0])
+	}
+
+	bodyLen := 0
+	for _, c := range header[1:HeaderSize] {
+		if c < '0' || '9' < c {
+			return 0, invalidPacket("malformed length %q", header[1:HeaderSize])
+		}
+		bodyLen = bodyLen*10 + int(c-'0')
+	}
+	if bodyLen < MinBodySize || MaxBodySize < bodyLen {
+		return 0, invalidPacket("length %d out of range", bodyLen)
+	}
+
+	return bodyLen, nil
+}
+
+// DecodeFrame decodes a frame read by ReadFrame. The payload is decrypted
+// with the shared key carried in the frame, or with key if there is none.
+// This matches responses; a captured request has to have its shared key
+// blanked out to be decrypted with the derived key.
+//
+// A frame whose status is not StatusOK is returned along with a *StatusError.
+func DecodeFrame(data []byte, key []byte) (*Frame, error) {
+	return decodeFrame(data, key, cipherOptions{})
+}
+
+func decodeFrame(data []byte, key []byte, opts cipherOptions) (*Frame, error) {
+	if len(data) < HeaderSize+MinBodySize {
+		return nil, invalidPacket("frame needs %d bytes, got %d", HeaderSize+MinBodySize, len(data))
+	}
+	bodyLen, err := parseHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != HeaderSize+bodyLen {
+		return nil, invalidPacket("length %d does not match body of %d bytes", bodyLen, len(data)-HeaderSize)
+	}
+
+	body := data[HeaderSize:]
+	sharedKeyRaw := body[:SharedKeySize]
+	statusRaw := body[SharedKeySize : SharedKeySize+4]
+	opcodeRaw := body[SharedKeySize+4 : SharedKeySize+4+3]
+
+	f := &Frame{
+		SharedKey: []byte(strings.TrimSpace(string(sharedKeyRaw))),
+		Status:    Status(statusRaw),
+	}
+
+	sharedKey, err := hex.DecodeString(string(f.SharedKey))
+	if err != nil {
+		return nil, invalidPacket("shared key: %v", err)
+	}
+
+	opcode, err := strconv.Atoi(string(opcodeRaw))
+	if err != nil {
+		return nil, invalidPacket("opcode %q", opcodeRaw)
+	}
+	f.OpCode = OpCode(opcode)
+
+	f.Payload = body[MinBodySize:]
+	if len(sharedKey) != 0 || len(key) != 0 {
+		if len(sharedKey) != 0 {
+			key = sharedKey
+		}
+		f.Payload, err = decryptWith(key, f.Payload, opts)
+		if err != nil {
+			return nil, invalidPacket("decrypt: %v", err)
+		}
+	}
+
+	if f.Status != StatusOK {
+		return f, &StatusError{
+			Status:  f.Status,
+			Message: decodeEUCKR(f.Payload),
+		}
+	}
+
+	return f, nil
+}
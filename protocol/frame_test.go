@@ -0,0 +1,119 @@
+package protocol
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestFrameRoundTrip(t *testing.T) {
+	sharedKey := []byte("0f1c7d6c75d313c4019815734a1a261aaaa487b9b01434c1e6fd8b8f2249bf75")
+	enc := NewEncryption(sharedKey, "1234567")
+
+	req := &Frame{
+		SharedKey: enc.SharedKey,
+		Status:    StatusOK,
+		OpCode:    OpUseHistory,
+		Payload:   []byte("00013"),
+	}
+	key, err := enc.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := EncodeFrame(req, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, []byte("S00087")) {
+		t.Fatalf("unexpected header %q", data[:HeaderSize])
+	}
+
+	// A request carries the shared key, but its payload is encrypted with the
+	// derived key, so the shared key must be dropped to decode it again.
+	copy(data[HeaderSize:], bytes.Repeat([]byte(" "), SharedKeySize))
+
+	got, err := DecodeFrame(data, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.OpCode != req.OpCode || got.Status != req.Status || !bytes.Equal(got.Payload, req.Payload) {
+		t.Errorf("decoded %+v, want %+v", got, req)
+	}
+}
+
+func TestDecodeFrameStatus(t *testing.T) {
+	data, err := EncodeFrame(&Frame{Status: "0001", OpCode: OpTime, Payload: []byte("\xbf\xc0\xb7\xf9")}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = DecodeFrame(data, nil)
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) {
+		t.Fatalf("expected a StatusError, got %v", err)
+	}
+	if statusErr.Status != "0001" || statusErr.Message != "오류" {
+		t.Errorf("unexpected %+v", statusErr)
+	}
+}
+
+func TestClientDo(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		data, err := ReadFrame(conn)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		req, err := DecodeFrame(data, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		resp, err := EncodeFrame(
+			&Frame{
+				SharedKey: []byte("00112233"),
+				Status:    StatusOK,
+				OpCode:    req.OpCode,
+				Payload:   append([]byte("echo:"), req.Payload...),
+			},
+			[]byte{0x00, 0x11, 0x22, 0x33},
+		)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conn.Write(resp)
+	}()
+
+	connected := 0
+	ctx := WithClientTrace(context.Background(), &ClientTrace{
+		Connected: func() { connected++ },
+	})
+
+	c := &Client{Addr: l.Addr().String()}
+	resp, err := c.Do(ctx, OpHelp, []byte("ping"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.OpCode != OpHelp || string(resp.Payload) != "echo:ping" {
+		t.Errorf("unexpected response %+v", resp)
+	}
+	if connected != 1 {
+		t.Errorf("Connected called %d times, want 1", connected)
+	}
+}
@@ -0,0 +1,54 @@
+package protocol
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func testFrame(opcode OpCode, payload string) []byte {
+	body := strings.Repeat(" ", SharedKeySize) + string(StatusOK) + fmt.Sprintf("%03d", int(opcode)) + payload
+	return []byte(fmt.Sprintf("S%05d%s", len(body), body))
+}
+
+func FuzzReadFrame(f *testing.F) {
+	f.Add(testFrame(OpTime, "\x00\x00\x00\x01"))
+	f.Add([]byte("S00000"))
+	f.Add([]byte("X00071"))
+	f.Add([]byte("S99999"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		frame, err := ReadFrame(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		if len(frame) < HeaderSize+MinBodySize || len(frame) > HeaderSize+MaxBodySize {
+			t.Fatalf("ReadFrame returned %d bytes", len(frame))
+		}
+	})
+}
+
+func FuzzDecodeFrame(f *testing.F) {
+	f.Add(testFrame(OpTime, "\x00\x00\x00\x01"), []byte(nil))
+	f.Add(testFrame(OpUseHistory, ""), []byte(nil))
+	f.Add(testFrame(999, ""), []byte(nil))
+	f.Add(testFrame(OpHelp, "0123456789abcdef"), []byte("key"))
+
+	f.Fuzz(func(t *testing.T, data []byte, key []byte) {
+		DecodeFrame(data, key)
+	})
+}
+
+func FuzzDecrypt(f *testing.F) {
+	f.Add([]byte("0f1c7d6c75d313c4"), make([]byte, 16))
+	f.Add([]byte("0f1c7d6c75d313c4"), []byte{})
+	f.Add([]byte{}, make([]byte, 17))
+
+	f.Fuzz(func(t *testing.T, sharedKey []byte, src []byte) {
+		dst, err := decrypt(sharedKey, src)
+		if err == nil && len(dst) > len(src) {
+			t.Fatalf("decrypt grew %d bytes to %d", len(src), len(dst))
+		}
+	})
+}
@@ -0,0 +1,65 @@
+// Package protocol implements the μOTP+ wire protocol: frame encoding,
+// SEED-CBC encryption of payloads and a client to exchange raw frames.
+//
+// It knows nothing about the layout of individual payloads; see the
+// parent package for a high-level API.
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+type OpCode int
+
+const (
+	OpInformation     OpCode = 402
+	OpTime            OpCode = 407
+	OpIssue           OpCode = 451
+	OpResetErrorCount OpCode = 452
+	OpUseHistory      OpCode = 453
+	OpHelp            OpCode = 454
+)
+
+func (op OpCode) String() string {
+	switch op {
+	case OpInformation:
+		return "information"
+	case OpTime:
+		return "time"
+	case OpIssue:
+		return "issue"
+	case OpResetErrorCount:
+		return "reset-error-count"
+	case OpUseHistory:
+		return "use-history"
+	case OpHelp:
+		return "help"
+	}
+	return "opcode(" + strconv.Itoa(int(op)) + ")"
+}
+
+type Status string
+
+const (
+	StatusOK Status = "0000"
+)
+
+var ErrInvalidPacket = errors.New("invalid packet")
+
+// invalidPacket wraps ErrInvalidPacket with a description of what was wrong.
+func invalidPacket(format string, a ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrInvalidPacket, fmt.Sprintf(format, a...))
+}
+
+// StatusError is returned when the server answers with a status other than
+// StatusOK. Message is the payload decoded from EUC-KR.
+type StatusError struct {
+	Status  Status
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("status: %s, payload: %s", e.Status, e.Message)
+}
@@ -0,0 +1,24 @@
+package protocol
+
+import "context"
+
+// ClientTrace holds functions called while a Client sends a request.
+type ClientTrace struct {
+	// Connected is called once the connection is established, just before
+	// the request is written.
+	Connected func()
+}
+
+type clientTraceKey struct{}
+
+// WithClientTrace returns a context whose requests call the functions of
+// trace.
+func WithClientTrace(ctx context.Context, trace *ClientTrace) context.Context {
+	return context.WithValue(ctx, clientTraceKey{}, trace)
+}
+
+// ContextClientTrace returns the ClientTrace of ctx, or nil.
+func ContextClientTrace(ctx context.Context) *ClientTrace {
+	trace, _ := ctx.Value(clientTraceKey{}).(*ClientTrace)
+	return trace
+}
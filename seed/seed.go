@@ -1,3 +1,10 @@
+// Package seed implements the SEED block cipher used by μOTP+.
+//
+// The key schedule sign-extends the key when rotating it, as the μOTP+ server
+// does. RFC4269KeySchedule selects the key schedule of RFC 4269 instead.
+//
+// The default implementation uses lookup tables indexed by secret data.
+// ConstantTime selects an implementation that computes the S-boxes instead.
 package seed
 
 import (
@@ -160,14 +167,56 @@ var (
 type seed struct {
 	cipher.Block
 	rkey [32]uint32
+	g    func(uint32) uint32
 }
 
-func NewCipher(key []byte) (cipher.Block, error) {
+type options struct {
+	constantTime       bool
+	rfc4269KeySchedule bool
+}
+
+type Option func(o *options)
+
+// ConstantTime computes the S-boxes instead of looking them up in tables,
+// so neither the time taken nor the memory accessed depends on the key or
+// the data. It is about two orders of magnitude slower than the table
+// implementation, which is still fast enough for short payloads.
+func ConstantTime() Option {
+	return func(o *options) {
+		o.constantTime = true
+	}
+}
+
+// RFC4269KeySchedule rotates the key with a logical shift, as RFC 4269
+// specifies. Without it the round keys differ from standard SEED for some
+// keys, as they do on the μOTP+ server.
+func RFC4269KeySchedule() Option {
+	return func(o *options) {
+		o.rfc4269KeySchedule = true
+	}
+}
+
+func NewCipher(key []byte, opts ...Option) (cipher.Block, error) {
 	if len(key) != 16 {
 		return nil, fmt.Errorf("invalid key size %d", len(key))
 	}
 
-	var s seed
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	s := seed{
+		g: g,
+	}
+	if o.constantTime {
+		s.g = gConstantTime
+	}
+
+	shift := shiftArithmetic
+	if o.rfc4269KeySchedule {
+		shift = shiftLogical
+	}
 
 	k := [4]uint32{
 		binary.BigEndian.Uint32(key[0:]),
@@ -192,8 +241,8 @@ func NewCipher(key []byte) (cipher.Block, error) {
 
 		k0 := k[0] + k[2] - KC[i]
 		k1 := k[1] - k[3] + KC[i]
-		s.rkey[i*2+0] = g(k0)
-		s.rkey[i*2+1] = g(k1)
+		s.rkey[i*2+0] = s.g(k0)
+		s.rkey[i*2+1] = s.g(k1)
 	}
 
 	return &s, nil
@@ -217,7 +266,7 @@ func (s *seed) Encrypt(dst, src []byte) {
 			t0 = block[2] ^ s.rkey[i+0]
 			t1 = block[3] ^ s.rkey[i+1]
 
-			t0, t1 = processBlock(t0, t1)
+			t0, t1 = s.processBlock(t0, t1)
 
 			block[0] ^= t0
 			block[1] ^= t1
@@ -225,7 +274,7 @@ func (s *seed) Encrypt(dst, src []byte) {
 			t0 = block[0] ^ s.rkey[i+0]
 			t1 = block[1] ^ s.rkey[i+1]
 
-			t0, t1 = processBlock(t0, t1)
+			t0, t1 = s.processBlock(t0, t1)
 
 			block[2] ^= t0
 			block[3] ^= t1
@@ -252,7 +301,7 @@ func (s *seed) Decrypt(dst, src []byte) {
 			t0 = block[0] ^ s.rkey[i+0]
 			t1 = block[1] ^ s.rkey[i+1]
 
-			t0, t1 = processBlock(t0, t1)
+			t0, t1 = s.processBlock(t0, t1)
 
 			block[2] ^= t0
 			block[3] ^= t1
@@ -260,7 +309,7 @@ func (s *seed) Decrypt(dst, src []byte) {
 			t0 = block[2] ^ s.rkey[i+0]
 			t1 = block[3] ^ s.rkey[i+1]
 
-			t0, t1 = processBlock(t0, t1)
+			t0, t1 = s.processBlock(t0, t1)
 
 			block[0] ^= t0
 			block[1] ^= t1
@@ -273,24 +322,24 @@ func (s *seed) Decrypt(dst, src []byte) {
 	binary.BigEndian.PutUint32(dst[12:], block[1])
 }
 
-func processBlock(t0, t1 uint32) (uint32, uint32) {
+func (s *seed) processBlock(t0, t1 uint32) (uint32, uint32) {
 	t1 ^= t0
-	t1 = g(t1)
+	t1 = s.g(t1)
 	t0 += t1
-	t0 = g(t0)
+	t0 = s.g(t0)
 	t1 += t0
-	t1 = g(t1)
+	t1 = s.g(t1)
 	t0 += t1
 
 	return t0, t1
 }
 
-func shift(a uint32, b int) uint32 {
-	if a&0x80000000 != 0 {
-		return (0xFFFFFFFF << (32 - b)) | uint32(a>>b)
-	} else {
-		return a >> b
-	}
+func shiftLogical(a uint32, b int) uint32 {
+	return a >> b
+}
+
+func shiftArithmetic(a uint32, b int) uint32 {
+	return uint32(int32(a) >> b)
 }
 
 func g(n uint32) uint32 {
@@ -0,0 +1,73 @@
+package seed
+
+// The S-boxes of SEED are S1(x) = A1 · x^247 ⊕ 169 and S2(x) = A2 · x^251 ⊕ 56
+// over GF(2^8) with the polynomial x^8 + x^6 + x^5 + x + 1. The functions
+// below compute them with no branches or memory accesses depending on x.
+
+// Columns of the affine matrices A1 and A2, least significant bit first.
+var (
+	s1Affine = [8]byte{0x2c, 0xd0, 0x69, 0xc2, 0x41, 0x44, 0x58, 0xe2}
+	s2Affine = [8]byte{0xd0, 0x2a, 0xe1, 0x2c, 0x21, 0x30, 0xa2, 0x6c}
+)
+
+// gfMul multiplies a and b in GF(2^8).
+func gfMul(a, b byte) byte {
+	var p byte
+	for i := 0; i < 8; i++ {
+		p ^= a & -(b & 1)
+		b >>= 1
+		carry := -(a >> 7)
+		a = (a << 1) ^ (0x63 & carry)
+	}
+	return p
+}
+
+// gfPow raises x to the public exponent e in GF(2^8).
+func gfPow(x byte, e uint8) byte {
+	r := byte(1)
+	for i := 7; i >= 0; i-- {
+		r = gfMul(r, r)
+		if e&(1<<i) != 0 {
+			r = gfMul(r, x)
+		}
+	}
+	return r
+}
+
+func affine(cols *[8]byte, v byte) byte {
+	var r byte
+	for i := 0; i < 8; i++ {
+		r ^= cols[i] & -(v >> i & 1)
+	}
+	return r
+}
+
+func s1(x byte) byte {
+	return affine(&s1Affine, gfPow(x, 247)) ^ 169
+}
+
+func s2(x byte) byte {
+	return affine(&s2Affine, gfPow(x, 251)) ^ 56
+}
+
+// gConstantTime is g computed without tables.
+func gConstantTime(n uint32) uint32 {
+	const (
+		m0 = 0xfc
+		m1 = 0xf3
+		m2 = 0xcf
+		m3 = 0x3f
+	)
+
+	y0 := s1(byte(n))
+	y1 := s2(byte(n >> 8))
+	y2 := s1(byte(n >> 16))
+	y3 := s2(byte(n >> 24))
+
+	z0 := (y0 & m0) ^ (y1 & m1) ^ (y2 & m2) ^ (y3 & m3)
+	z1 := (y0 & m1) ^ (y1 & m2) ^ (y2 & m3) ^ (y3 & m0)
+	z2 := (y0 & m2) ^ (y1 & m3) ^ (y2 & m0) ^ (y3 & m1)
+	z3 := (y0 & m3) ^ (y1 & m0) ^ (y2 & m1) ^ (y3 & m2)
+
+	return uint32(z0) | uint32(z1)<<8 | uint32(z2)<<16 | uint32(z3)<<24
+}
@@ -2,7 +2,9 @@ package seed
 
 import (
 	"bytes"
+	"crypto/cipher"
 	"encoding/hex"
+	"math/rand"
 	"testing"
 )
 
@@ -27,3 +29,184 @@ func TestEncrypt(t *testing.T) {
 		t.Errorf("Data : %s\nEnc  : %s\nDec  : %s", hex.Dump(data), hex.Dump(dstEnc), hex.Dump(dstDec))
 	}
 }
+
+// Known answer tests from RFC 4269, appendix B.
+var knownAnswers = []struct {
+	key        string
+	plaintext  string
+	ciphertext string
+}{
+	{"00000000000000000000000000000000", "000102030405060708090A0B0C0D0E0F", "5EBAC6E0054E166819AFF1CC6D346CDB"},
+	{"000102030405060708090A0B0C0D0E0F", "00000000000000000000000000000000", "C11F22F20140505084483597E4370F43"},
+	{"4706480851E61BE85D74BFB3FD956185", "83A2F8A288641FB9A4E9A5CC2F131C7D", "EE54D13EBCAE706D226BC3142CD40D4A"},
+	{"28DBC3BC49FFD87DCFA509B11D422BE7", "B41E6BE2EBA84A148E2EED84593C5EC7", "9B9B7BFCD1813CB95D0B3618F40F5122"},
+}
+
+var implementations = []struct {
+	name string
+	opts []Option
+}{
+	{"Table", nil},
+	{"ConstantTime", []Option{ConstantTime()}},
+}
+
+func mustHex(t testing.TB, s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestKnownAnswer(t *testing.T) {
+	for _, impl := range implementations {
+		t.Run(impl.name, func(t *testing.T) {
+			for _, c := range knownAnswers {
+				b, err := NewCipher(mustHex(t, c.key), append([]Option{RFC4269KeySchedule()}, impl.opts...)...)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				plaintext := mustHex(t, c.plaintext)
+				ciphertext := mustHex(t, c.ciphertext)
+
+				dst := make([]byte, BlockSize)
+				b.Encrypt(dst, plaintext)
+				if !bytes.Equal(dst, ciphertext) {
+					t.Errorf("key %s: encrypted to %X, want %s", c.key, dst, c.ciphertext)
+				}
+
+				b.Decrypt(dst, ciphertext)
+				if !bytes.Equal(dst, plaintext) {
+					t.Errorf("key %s: decrypted to %X, want %s", c.key, dst, c.plaintext)
+				}
+			}
+		})
+	}
+}
+
+// SEED-CBC answers for the key and IV of the KISA reference code, as given
+// by OpenSSL with "openssl enc -seed-cbc -nopad".
+var cbcAnswers = []struct {
+	key        string
+	iv         string
+	plaintext  string
+	ciphertext string
+}{
+	{
+		"88E34F8F081779F1E9F394370AD40589", "268D66A735A81A816FBAD9FA36162501",
+		"000102030405060708090A0B0C0D0E0F101112131415161718191A1B1C1D1E1F",
+		"75DDA4B065FF86427D448C5403D35A070F40537B83AC80B84355E30FB0E3859A",
+	},
+	{
+		"000102030405060708090A0B0C0D0E0F", "00000000000000000000000000000000",
+		"000102030405060708090A0B0C0D0E0F101112131415161718191A1B1C1D1E1F",
+		"A6E8D7325BBE0998CF235C1B57E643603DE8495FFCB87D9AB1ACF793DDFCA63D",
+	},
+}
+
+func TestCBC(t *testing.T) {
+	for _, impl := range implementations {
+		t.Run(impl.name, func(t *testing.T) {
+			for _, c := range cbcAnswers {
+				b, err := NewCipher(mustHex(t, c.key), append([]Option{RFC4269KeySchedule()}, impl.opts...)...)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				iv := mustHex(t, c.iv)
+				plaintext := mustHex(t, c.plaintext)
+				ciphertext := mustHex(t, c.ciphertext)
+
+				dst := make([]byte, len(plaintext))
+				cipher.NewCBCEncrypter(b, iv).CryptBlocks(dst, plaintext)
+				if !bytes.Equal(dst, ciphertext) {
+					t.Errorf("key %s: encrypted to %X, want %s", c.key, dst, c.ciphertext)
+				}
+
+				cipher.NewCBCDecrypter(b, iv).CryptBlocks(dst, ciphertext)
+				if !bytes.Equal(dst, plaintext) {
+					t.Errorf("key %s: decrypted to %X, want %s", c.key, dst, c.plaintext)
+				}
+			}
+		})
+	}
+}
+
+func TestSBoxes(t *testing.T) {
+	for x := 0; x < 256; x++ {
+		if want := byte(ss0[x]) | byte(ss0[x]>>24); s1(byte(x)) != want {
+			t.Errorf("S1(%#02x) = %#02x, want %#02x", x, s1(byte(x)), want)
+		}
+		if want := byte(ss1[x]) | byte(ss1[x]>>24); s2(byte(x)) != want {
+			t.Errorf("S2(%#02x) = %#02x, want %#02x", x, s2(byte(x)), want)
+		}
+	}
+}
+
+func TestConstantTimeMatchesTable(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	for _, rfc4269 := range []bool{false, true} {
+		for i := 0; i < 100; i++ {
+			key := make([]byte, 16)
+			src := make([]byte, BlockSize)
+			r.Read(key)
+			r.Read(src)
+
+			var opts []Option
+			if rfc4269 {
+				opts = append(opts, RFC4269KeySchedule())
+			}
+			table, _ := NewCipher(key, opts...)
+			ct, _ := NewCipher(key, append(opts, ConstantTime())...)
+
+			a := make([]byte, BlockSize)
+			b := make([]byte, BlockSize)
+			table.Encrypt(a, src)
+			ct.Encrypt(b, src)
+			if !bytes.Equal(a, b) {
+				t.Fatalf("RFC 4269 %v, key %X: table %X, constant time %X", rfc4269, key, a, b)
+			}
+		}
+	}
+}
+
+func BenchmarkEncrypt(b *testing.B) {
+	for _, impl := range implementations {
+		b.Run(impl.name, func(b *testing.B) {
+			c, _ := NewCipher(make([]byte, 16), impl.opts...)
+			buf := make([]byte, BlockSize)
+
+			b.SetBytes(BlockSize)
+			for i := 0; i < b.N; i++ {
+				c.Encrypt(buf, buf)
+			}
+		})
+	}
+}
+
+func BenchmarkDecrypt(b *testing.B) {
+	for _, impl := range implementations {
+		b.Run(impl.name, func(b *testing.B) {
+			c, _ := NewCipher(make([]byte, 16), impl.opts...)
+			buf := make([]byte, BlockSize)
+
+			b.SetBytes(BlockSize)
+			for i := 0; i < b.N; i++ {
+				c.Decrypt(buf, buf)
+			}
+		})
+	}
+}
+
+func BenchmarkNewCipher(b *testing.B) {
+	for _, impl := range implementations {
+		b.Run(impl.name, func(b *testing.B) {
+			key := make([]byte, 16)
+			for i := 0; i < b.N; i++ {
+				NewCipher(key, impl.opts...)
+			}
+		})
+	}
+}
@@ -0,0 +1,362 @@
+package uotp
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSyncInterval      = time.Hour
+	defaultSyncCheckInterval = 10 * time.Second
+	defaultSyncRetryDelay    = 10 * time.Second
+	defaultClockJump         = 2 * time.Second
+	defaultMaxDrift          = 500 * time.Millisecond
+	defaultMinSyncInterval   = time.Minute
+
+	// driftHistorySize is how many synchronizations the drift is measured over.
+	driftHistorySize = 32
+	// minDriftSpan is how long the drift history must span before the drift
+	// rate is trusted.
+	minDriftSpan = 10 * time.Minute
+)
+
+// SyncReason is why a SyncManager synchronized the time.
+type SyncReason string
+
+const (
+	SyncReasonStart    SyncReason = "start"
+	SyncReasonSchedule SyncReason = "schedule"
+	SyncReasonRetry    SyncReason = "retry"
+	SyncReasonJump     SyncReason = "clock_jump"
+	SyncReasonZone     SyncReason = "zone_change"
+	SyncReasonDrift    SyncReason = "drift"
+	SyncReasonManual   SyncReason = "manual"
+)
+
+// DriftSample is the offset measured by one synchronization.
+type DriftSample struct {
+	At          time.Time
+	Offset      time.Duration
+	Uncertainty time.Duration
+}
+
+// SyncHealth reports the state of a SyncManager.
+type SyncHealth struct {
+	// Healthy is true when the last synchronization succeeded, is recent
+	// and the offset is not expected to have drifted too far since.
+	Healthy bool
+
+	LastSync    time.Time
+	LastAttempt time.Time
+	LastReason  SyncReason
+	LastError   error
+	// Failures is how many synchronizations failed in a row.
+	Failures int
+
+	Offset      time.Duration
+	Uncertainty time.Duration
+	Confidence  float64
+	// Source is the time source of the last synchronization, and
+	// Authoritative whether it is trusted like the server.
+	Source        string
+	Authoritative bool
+
+	// DriftRate is how fast the offset changes, in seconds per second.
+	// It is zero until the drift history spans ten minutes.
+	DriftRate float64
+	// ExpectedDrift is how far the offset is expected to have drifted since
+	// the last synchronization.
+	ExpectedDrift time.Duration
+}
+
+// TimeSyncer synchronizes the time. UOTP and *Keyring implement it.
+type TimeSyncer interface {
+	SyncTime(ctx context.Context) (*SyncResult, error)
+}
+
+// SyncManager keeps the time of an account synchronized in the background,
+// so tokens can be generated without waiting for the network.
+//
+//	m := &uotp.SyncManager{OTP: otp}
+//	go m.Run(ctx)
+//	token := m.Token()
+//
+// It synchronizes on a schedule, when the system clock jumps or the time
+// zone changes, and early when the measured drift of the local clock
+// suggests the offset is stale.
+type SyncManager struct {
+	OTP UOTP
+	// Syncer, if set, is synchronized instead of OTP, e.g. a *Keyring to
+	// keep all of its accounts synchronized. Token needs OTP.
+	Syncer TimeSyncer
+
+	// Interval is the time between synchronizations. It defaults to an hour.
+	Interval time.Duration
+	// MinInterval is the least time between synchronizations caused by
+	// drift. It defaults to a minute.
+	MinInterval time.Duration
+	// CheckInterval is how often the clock is checked for jumps and drift.
+	// It defaults to 10 seconds.
+	CheckInterval time.Duration
+	// RetryDelay is the delay before retrying a failed synchronization. It
+	// doubles after each failure, up to Interval. It defaults to 10 seconds.
+	RetryDelay time.Duration
+	// ClockJump is the difference between the wall clock and the monotonic
+	// clock taken as a jump of the system clock. It defaults to 2 seconds.
+	ClockJump time.Duration
+	// MaxDrift is how far the offset may be expected to have drifted before
+	// synchronizing early. It defaults to 500 milliseconds.
+	MaxDrift time.Duration
+
+	// OnSync is called after each synchronization with its result or error.
+	OnSync func(reason SyncReason, r *SyncResult, err error)
+
+	// wall and mono are replaced in tests.
+	wall func() time.Time
+	mono func() time.Duration
+
+	syncMu sync.Mutex
+
+	mu          sync.Mutex
+	started     bool
+	health      SyncHealth
+	history     []DriftSample
+	lastSyncMon time.Duration
+	checkWall   time.Time
+	checkMono   time.Duration
+	nextRetry   time.Duration
+	retryDelay  time.Duration
+}
+
+func (m *SyncManager) init() {
+	if m.started {
+		return
+	}
+	m.started = true
+
+	if m.wall == nil {
+		m.wall = time.Now
+	}
+	if m.mono == nil {
+		start := time.Now()
+		m.mono = func() time.Duration { return time.Since(start) }
+	}
+}
+
+func durationOr(d, def time.Duration) time.Duration {
+	if d <= 0 {
+		return def
+	}
+	return d
+}
+
+// Token generates a token from the current offset. It never blocks on the
+// network.
+func (m *SyncManager) Token() string {
+	return m.OTP.GenerateToken()
+}
+
+// Run synchronizes the time at once, then keeps it synchronized until ctx
+// is done. It returns ctx.Err().
+func (m *SyncManager) Run(ctx context.Context) error {
+	m.mu.Lock()
+	m.init()
+	m.mu.Unlock()
+
+	m.sync(ctx, SyncReasonStart)
+
+	ticker := time.NewTicker(durationOr(m.CheckInterval, defaultSyncCheckInterval))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		if reason, ok := m.due(); ok {
+			m.sync(ctx, reason)
+		}
+	}
+}
+
+// Sync synchronizes the time now.
+func (m *SyncManager) Sync(ctx context.Context) (*SyncResult, error) {
+	m.mu.Lock()
+	m.init()
+	m.mu.Unlock()
+
+	return m.sync(ctx, SyncReasonManual)
+}
+
+func (m *SyncManager) sync(ctx context.Context, reason SyncReason) (*SyncResult, error) {
+	m.syncMu.Lock()
+	defer m.syncMu.Unlock()
+
+	syncer := m.Syncer
+	if syncer == nil {
+		syncer = m.OTP
+	}
+	r, err := syncer.SyncTime(ctx)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	m.mu.Lock()
+	now, mono := m.wall(), m.mono()
+	m.health.LastAttempt = now
+	m.health.LastReason = reason
+	m.health.LastError = err
+	if err != nil {
+		m.health.Failures++
+
+		m.retryDelay = durationOr(m.retryDelay*2, durationOr(m.RetryDelay, defaultSyncRetryDelay))
+		if interval := durationOr(m.Interval, defaultSyncInterval); m.retryDelay > interval {
+			m.retryDelay = interval
+		}
+		m.nextRetry = mono + m.retryDelay
+	} else {
+		m.health.Failures = 0
+		m.health.LastSync = now
+		m.health.Offset = r.Offset
+		m.health.Uncertainty = r.Uncertainty
+		m.health.Confidence = r.Confidence
+		m.health.Source = r.Source
+		m.health.Authoritative = r.Authoritative
+		m.lastSyncMon = mono
+		m.retryDelay = 0
+
+		m.history = append(m.history, DriftSample{
+			At:          now,
+			Offset:      r.Offset,
+			Uncertainty: r.Uncertainty,
+		})
+		if len(m.history) > driftHistorySize {
+			m.history = m.history[len(m.history)-driftHistorySize:]
+		}
+		m.health.DriftRate = driftRate(m.history)
+	}
+	m.checkWall, m.checkMono = now, mono
+	m.mu.Unlock()
+
+	if m.OnSync != nil {
+		m.OnSync(reason, r, err)
+	}
+	return r, err
+}
+
+// due reports whether the time should be synchronized now, and why.
+func (m *SyncManager) due() (SyncReason, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now, mono := m.wall(), m.mono()
+	prevWall, prevMono := m.checkWall, m.checkMono
+	m.checkWall, m.checkMono = now, mono
+
+	// The offset includes the time zone, and the wall clock does not move
+	// with the monotonic clock after it is set. Either invalidates the
+	// offset and the drift measured so far.
+	if _, zone := now.Zone(); !prevWall.IsZero() {
+		if _, prevZone := prevWall.Zone(); zone != prevZone {
+			m.resetDrift()
+			return SyncReasonZone, true
+		}
+		// Round(0) strips the monotonic reading, which Sub would use.
+		jump := durationOr(m.ClockJump, defaultClockJump)
+		if d := now.Round(0).Sub(prevWall.Round(0)) - (mono - prevMono); d < -jump || jump < d {
+			m.resetDrift()
+			return SyncReasonJump, true
+		}
+	}
+
+	if m.health.Failures > 0 {
+		return SyncReasonRetry, mono >= m.nextRetry
+	}
+
+	elapsed := mono - m.lastSyncMon
+	if elapsed >= durationOr(m.Interval, defaultSyncInterval) {
+		return SyncReasonSchedule, true
+	}
+
+	if elapsed >= durationOr(m.MinInterval, defaultMinSyncInterval) && m.expectedDrift(elapsed) > durationOr(m.MaxDrift, defaultMaxDrift) {
+		return SyncReasonDrift, true
+	}
+
+	return "", false
+}
+
+// resetDrift forgets the offsets and the drift rate measured so far. m.mu
+// must be held.
+func (m *SyncManager) resetDrift() {
+	m.history = nil
+	m.health.DriftRate = 0
+}
+
+// expectedDrift is how far the offset is expected to have drifted after
+// elapsed.
+func (m *SyncManager) expectedDrift(elapsed time.Duration) time.Duration {
+	drift := time.Duration(m.health.DriftRate * float64(elapsed))
+	if drift < 0 {
+		drift = -drift
+	}
+	return drift
+}
+
+// driftRate fits a line through the offsets by least squares and returns its
+// slope.
+func driftRate(history []DriftSample) float64 {
+	if len(history) < 2 || history[len(history)-1].At.Sub(history[0].At) < minDriftSpan {
+		return 0
+	}
+
+	t0 := history[0].At
+	var sx, sy, sxx, sxy float64
+	for _, s := range history {
+		x := s.At.Sub(t0).Seconds()
+		y := s.Offset.Seconds()
+		sx += x
+		sy += y
+		sxx += x * x
+		sxy += x * y
+	}
+
+	n := float64(len(history))
+	d := n*sxx - sx*sx
+	if d == 0 {
+		return 0
+	}
+	return (n*sxy - sx*sy) / d
+}
+
+// Health reports the state of the manager.
+func (m *SyncManager) Health() SyncHealth {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.init()
+
+	h := m.health
+	if h.LastSync.IsZero() {
+		return h
+	}
+
+	elapsed := m.mono() - m.lastSyncMon
+	h.ExpectedDrift = m.expectedDrift(elapsed)
+	h.Healthy = h.Failures == 0 &&
+		elapsed < 2*durationOr(m.Interval, defaultSyncInterval) &&
+		h.ExpectedDrift <= 2*durationOr(m.MaxDrift, defaultMaxDrift)
+	return h
+}
+
+// DriftHistory returns the offsets measured by the last synchronizations,
+// oldest first. It is cleared when the clock jumps.
+func (m *SyncManager) DriftHistory() []DriftSample {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r := make([]DriftSample, len(m.history))
+	copy(r, m.history)
+	return r
+}
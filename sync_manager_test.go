@@ -0,0 +1,195 @@
+package uotp
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClocks drives the wall and monotonic clocks of a SyncManager.
+type fakeClocks struct {
+	wall time.Time
+	mono time.Duration
+}
+
+func (c *fakeClocks) advance(d time.Duration) {
+	c.wall = c.wall.Add(d)
+	c.mono += d
+}
+
+// manager returns a SyncManager of u running on the clocks.
+func (c *fakeClocks) manager(u UOTP) *SyncManager {
+	return &SyncManager{
+		OTP:  u,
+		wall: func() time.Time { return c.wall },
+		mono: func() time.Duration { return c.mono },
+	}
+}
+
+func TestSyncManagerSchedule(t *testing.T) {
+	c := &fakeClocks{wall: time.Date(2022, 3, 1, 12, 0, 0, 0, serverLocation)}
+	m := c.manager(newTestUOTP(t, &fakeServer{t: t, clock: &c.wall, skew: constantSkew(5 * time.Second)}))
+
+	if _, err := m.Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	h := m.Health()
+	if !h.Healthy || h.LastReason != SyncReasonManual || h.Offset < 4*time.Second || 6*time.Second < h.Offset {
+		t.Errorf("health = %+v", h)
+	}
+
+	c.advance(30 * time.Minute)
+	if reason, ok := m.due(); ok {
+		t.Errorf("due after 30 minutes: %s", reason)
+	}
+	c.advance(30 * time.Minute)
+	if reason, ok := m.due(); !ok || reason != SyncReasonSchedule {
+		t.Errorf("due after an hour = %s, %v", reason, ok)
+	}
+}
+
+func TestSyncManagerClockJump(t *testing.T) {
+	c := &fakeClocks{wall: time.Date(2022, 3, 1, 12, 0, 0, 0, serverLocation)}
+	m := c.manager(newTestUOTP(t, &fakeServer{t: t, clock: &c.wall}))
+	if _, err := m.Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	m.health.DriftRate = 0.001
+	c.advance(10 * time.Second)
+	c.wall = c.wall.Add(-time.Minute)
+	if reason, ok := m.due(); !ok || reason != SyncReasonJump {
+		t.Errorf("due after jump = %s, %v", reason, ok)
+	}
+	if len(m.DriftHistory()) != 0 {
+		t.Error("drift history kept across a jump")
+	}
+	if m.Health().DriftRate != 0 {
+		t.Error("drift rate kept across a jump")
+	}
+
+	m.health.DriftRate = 0.001
+	c.advance(10 * time.Second)
+	c.wall = c.wall.In(time.FixedZone("", 3600*5+1))
+	if reason, ok := m.due(); !ok || reason != SyncReasonZone {
+		t.Errorf("due after zone change = %s, %v", reason, ok)
+	}
+	if m.Health().DriftRate != 0 {
+		t.Error("drift rate kept across a zone change")
+	}
+}
+
+func TestSyncManagerDrift(t *testing.T) {
+	var offset time.Duration
+	c := &fakeClocks{wall: time.Date(2022, 3, 1, 12, 0, 0, 0, serverLocation)}
+	m := c.manager(newTestUOTP(t, &fakeServer{
+		t:     t,
+		clock: &c.wall,
+		skew:  func(int) time.Duration { return offset },
+	}))
+
+	// The local clock loses 1ms a second.
+	for i := 0; i < 4; i++ {
+		if _, err := m.Sync(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		c.advance(10 * time.Minute)
+		offset += 600 * time.Millisecond
+	}
+
+	h := m.Health()
+	if h.DriftRate < 0.0005 || 0.0015 < h.DriftRate {
+		t.Errorf("drift rate = %f", h.DriftRate)
+	}
+	if len(m.DriftHistory()) != 4 {
+		t.Errorf("drift history has %d samples", len(m.DriftHistory()))
+	}
+	c.advance(10 * time.Minute)
+	if reason, ok := m.due(); !ok || reason != SyncReasonDrift {
+		t.Errorf("due = %s, %v", reason, ok)
+	}
+}
+
+func TestSyncManagerRetry(t *testing.T) {
+	c := &fakeClocks{wall: time.Date(2022, 3, 1, 12, 0, 0, 0, serverLocation)}
+	f := &fakeServer{t: t, clock: &c.wall, err: errOffline}
+	m := c.manager(newTestUOTP(t, f))
+	m.RetryDelay = time.Minute
+
+	if _, err := m.Sync(context.Background()); err == nil {
+		t.Fatal("expected an error")
+	}
+	h := m.Health()
+	if h.Healthy || h.Failures != 1 || h.LastError == nil {
+		t.Errorf("health = %+v", h)
+	}
+
+	c.advance(30 * time.Second)
+	if _, ok := m.due(); ok {
+		t.Error("retried too early")
+	}
+	c.advance(30 * time.Second)
+	if reason, ok := m.due(); !ok || reason != SyncReasonRetry {
+		t.Errorf("due = %s, %v", reason, ok)
+	}
+
+	// The delay doubles.
+	m.Sync(context.Background())
+	c.advance(time.Minute)
+	if _, ok := m.due(); ok {
+		t.Error("retry delay did not double")
+	}
+
+	f.err = nil
+	if _, err := m.Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if h := m.Health(); !h.Healthy || h.Failures != 0 {
+		t.Errorf("health = %+v", h)
+	}
+}
+
+func TestSyncManagerRun(t *testing.T) {
+	u := newTestUOTP(t, &fakeServer{t: t})
+
+	var mu sync.Mutex
+	var reasons []SyncReason
+	synced := make(chan struct{}, 1)
+
+	m := &SyncManager{
+		OTP:           u,
+		CheckInterval: time.Millisecond,
+		OnSync: func(reason SyncReason, r *SyncResult, err error) {
+			mu.Lock()
+			reasons = append(reasons, reason)
+			mu.Unlock()
+			select {
+			case synced <- struct{}{}:
+			default:
+			}
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- m.Run(ctx) }()
+
+	<-synced
+	for i := 0; i < 10; i++ {
+		if len(m.Token()) == 0 {
+			t.Error("empty token")
+		}
+		m.Health()
+	}
+	cancel()
+
+	if err := <-done; err != context.Canceled {
+		t.Errorf("Run returned %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(reasons) == 0 || reasons[0] != SyncReasonStart {
+		t.Errorf("reasons = %v", reasons)
+	}
+}
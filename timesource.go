@@ -0,0 +1,276 @@
+package uotp
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ServerTimeSource is the name of the μOTP+ server as a time source.
+const ServerTimeSource = "uotp"
+
+// serverLocation is the time zone of the μOTP+ server. Fallback sources give
+// the time in UTC, which is converted to the scale of the server in it.
+var serverLocation = time.FixedZone("KST", 9*60*60)
+
+// TimeSample is the time read from a TimeSource.
+type TimeSample struct {
+	// Time is the time of the source, halfway through the request.
+	Time time.Time
+	// Resolution is the precision of Time. It is one second for a time
+	// that is truncated to the second.
+	Resolution time.Duration
+}
+
+// TimeSource tells the time when the μOTP+ server cannot.
+type TimeSource interface {
+	// Name identifies the source in SyncResult.
+	Name() string
+	// Authoritative reports whether the source is trusted like the server.
+	Authoritative() bool
+	// Sample reads the time once.
+	Sample(ctx context.Context) (*TimeSample, error)
+}
+
+// WithTimeSources sets the sources SyncTime falls back to, in order, when the
+// μOTP+ server does not answer.
+func WithTimeSources(sources ...TimeSource) Option {
+	return func(u *uotp) {
+		u.timeSources = sources
+	}
+}
+
+// ParseTimeSource returns the time source described by s: "ntp://host[:port]"
+// for SNTP, or an http or https URL whose Date header is read.
+func ParseTimeSource(s string) (TimeSource, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "ntp", "sntp":
+		if u.Host == "" {
+			return nil, fmt.Errorf("time source %q: missing host", s)
+		}
+		return NewSNTPTimeSource(u.Host), nil
+	case "http", "https":
+		return NewHTTPTimeSource(s, nil), nil
+	}
+	return nil, fmt.Errorf("time source %q: unknown scheme", s)
+}
+
+type fixedTimeSource struct {
+	t       time.Time
+	created time.Time
+}
+
+// NewFixedTimeSource returns a trusted source whose time was t when it was
+// created. It is meant for tests.
+func NewFixedTimeSource(t time.Time) TimeSource {
+	return &fixedTimeSource{
+		t:       t,
+		created: time.Now(),
+	}
+}
+
+func (s *fixedTimeSource) Name() string {
+	return "fixed"
+}
+
+func (s *fixedTimeSource) Authoritative() bool {
+	return true
+}
+
+func (s *fixedTimeSource) Sample(ctx context.Context) (*TimeSample, error) {
+	return &TimeSample{
+		Time: s.t.Add(time.Since(s.created)),
+	}, nil
+}
+
+type httpTimeSource struct {
+	url    string
+	client *http.Client
+}
+
+// NewHTTPTimeSource returns a source reading the Date header of the response
+// to a HEAD request of url. http.DefaultClient is used if client is nil.
+func NewHTTPTimeSource(url string, client *http.Client) TimeSource {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &httpTimeSource{
+		url:    url,
+		client: client,
+	}
+}
+
+func (s *httpTimeSource) Name() string {
+	return s.url
+}
+
+func (s *httpTimeSource) Authoritative() bool {
+	return false
+}
+
+func (s *httpTimeSource) Sample(ctx context.Context) (*TimeSample, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, s.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	// A cached response would carry an old date.
+	req.Header.Set("Cache-Control", "no-cache")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	date := resp.Header.Get("Date")
+	if date == "" {
+		return nil, fmt.Errorf("%s: no Date header", s.url)
+	}
+	t, err := http.ParseTime(date)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", s.url, err)
+	}
+
+	return &TimeSample{
+		Time:       t,
+		Resolution: time.Second,
+	}, nil
+}
+
+type sntpTimeSource struct {
+	addr string
+}
+
+// NewSNTPTimeSource returns a source asking an NTP server at addr, with port
+// 123 if addr has none, as described in RFC 4330.
+func NewSNTPTimeSource(addr string) TimeSource {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(strings.Trim(addr, "[]"), "123")
+	}
+	return &sntpTimeSource{
+		addr: addr,
+	}
+}
+
+func (s *sntpTimeSource) Name() string {
+	return "ntp://" + s.addr
+}
+
+func (s *sntpTimeSource) Authoritative() bool {
+	return false
+}
+
+// ntpEpochOffset is the number of seconds from 1900 to 1970.
+const ntpEpochOffset = 2208988800
+
+var errInvalidSNTP = errors.New("invalid SNTP response")
+
+func ntpTime(b []byte) time.Time {
+	sec := binary.BigEndian.Uint32(b[0:])
+	frac := binary.BigEndian.Uint32(b[4:])
+	nsec := (uint64(frac) * 1e9) >> 32
+	return time.Unix(int64(sec)-ntpEpochOffset, int64(nsec))
+}
+
+func (s *sntpTimeSource) Sample(ctx context.Context) (*TimeSample, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", s.addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(5 * time.Second)
+	}
+	conn.SetDeadline(deadline)
+
+	// LI 0, version 4, mode 3 (client). The transmit timestamp is random, and
+	// must come back as the originate timestamp.
+	var req [48]byte
+	req[0] = 0<<6 | 4<<3 | 3
+	if _, err = rand.Read(req[40:]); err != nil {
+		return nil, err
+	}
+
+	if _, err = conn.Write(req[:]); err != nil {
+		return nil, err
+	}
+
+	var resp [48]byte
+	n, err := conn.Read(resp[:])
+	if err != nil {
+		return nil, err
+	}
+
+	leap, mode, stratum := resp[0]>>6, resp[0]&7, resp[1]
+	switch {
+	case n < len(resp):
+		return nil, fmt.Errorf("%w: %d bytes", errInvalidSNTP, n)
+	case mode != 4:
+		return nil, fmt.Errorf("%w: mode %d", errInvalidSNTP, mode)
+	case leap == 3 || stratum == 0 || stratum > 15:
+		return nil, fmt.Errorf("%w: server is not synchronized", errInvalidSNTP)
+	case string(resp[24:32]) != string(req[40:48]):
+		return nil, fmt.Errorf("%w: originate timestamp does not match", errInvalidSNTP)
+	}
+
+	// The server received the request at T2 and answered at T3.
+	t2, t3 := ntpTime(resp[32:]), ntpTime(resp[40:])
+	return &TimeSample{
+		Time: t2.Add(t3.Sub(t2) / 2),
+	}, nil
+}
+
+// timeSampler takes one sample for syncTime.
+type timeSampler interface {
+	name() string
+	authoritative() bool
+	sample(ctx context.Context, u *uotp) (*syncSample, error)
+}
+
+type serverSampler struct{}
+
+func (serverSampler) name() string        { return ServerTimeSource }
+func (serverSampler) authoritative() bool { return true }
+
+func (serverSampler) sample(ctx context.Context, u *uotp) (*syncSample, error) {
+	return u.sampleTime(ctx)
+}
+
+type sourceSampler struct {
+	src TimeSource
+}
+
+func (s sourceSampler) name() string        { return s.src.Name() }
+func (s sourceSampler) authoritative() bool { return s.src.Authoritative() }
+
+func (s sourceSampler) sample(ctx context.Context, u *uotp) (*syncSample, error) {
+	sentAt := u.now()
+	r, err := s.src.Sample(ctx)
+	receivedAt := u.now()
+	if err != nil {
+		return nil, err
+	}
+
+	return &syncSample{
+		sentAt:     sentAt,
+		sent:       otpClock(sentAt),
+		received:   otpClock(receivedAt),
+		server:     otpClock(r.Time.In(serverLocation).Truncate(durationOr(r.Resolution, time.Nanosecond))),
+		resolution: r.Resolution,
+	}, nil
+}
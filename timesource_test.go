@@ -0,0 +1,175 @@
+package uotp
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSyncTimeFixedSource(t *testing.T) {
+	now := time.Now()
+	u := newTestUOTP(t, &fakeServer{t: t, err: errOffline}, WithTimeSources(NewFixedTimeSource(now.Add(90*time.Minute))))
+
+	r, err := u.SyncTime(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Source != "fixed" || !r.Authoritative {
+		t.Errorf("got %+v", r)
+	}
+
+	want := otpClock(now.Add(90*time.Minute).In(serverLocation)) - otpClock(now)
+	if d := r.Offset - want; d < -100*time.Millisecond || 100*time.Millisecond < d {
+		t.Errorf("offset %s, want %s", r.Offset, want)
+	}
+	a := u.GetAccount()
+	if a.TimeSource != "fixed" || a.TimeFallback {
+		t.Errorf("account has %q, fallback %v", a.TimeSource, a.TimeFallback)
+	}
+	if a.TimeSyncedAt == nil || !a.TimeSyncedAt.Equal(r.At) || r.At.Before(now) {
+		t.Errorf("account synced at %v, result at %s", a.TimeSyncedAt, r.At)
+	}
+
+	// The time of the synchronization is kept with the account.
+	loaded, err := New(&a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := loaded.GetAccount().TimeSyncedAt; got == nil || !got.Equal(r.At) {
+		t.Errorf("loaded account synced at %v, want %s", got, r.At)
+	}
+}
+
+func TestSyncTimeSourcePriority(t *testing.T) {
+	noDate := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header()["Date"] = nil
+	}))
+	defer noDate.Close()
+
+	date := time.Date(2022, 3, 1, 3, 0, 0, 0, time.UTC)
+	withDate := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead {
+			t.Errorf("method %s", r.Method)
+		}
+		w.Header().Set("Date", date.Format(http.TimeFormat))
+	}))
+	defer withDate.Close()
+
+	u := newTestUOTP(t, &fakeServer{t: t, err: errOffline}, WithTimeSources(
+		NewHTTPTimeSource(noDate.URL, noDate.Client()),
+		NewHTTPTimeSource(withDate.URL, withDate.Client()),
+	))
+
+	clock := time.Date(2022, 3, 1, 11, 59, 0, 0, serverLocation)
+	u.now = func() time.Time { return clock }
+
+	r, err := u.SyncTime(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Source != withDate.URL || r.Authoritative {
+		t.Errorf("got %+v", r)
+	}
+	if want := time.Minute + 500*time.Millisecond; r.Offset != want {
+		t.Errorf("offset %s, want %s", r.Offset, want)
+	}
+	if a := u.GetAccount(); a.TimeSource != withDate.URL || !a.TimeFallback || a.TimeUncertainty != r.Uncertainty {
+		t.Errorf("account = %+v", a)
+	}
+}
+
+func TestSyncTimeAllSourcesFail(t *testing.T) {
+	u := newTestUOTP(t, &fakeServer{t: t, err: errOffline}, WithTimeSources(NewHTTPTimeSource("http://127.0.0.1:0", nil)))
+
+	_, err := u.SyncTime(context.Background())
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+// sntpServer answers one SNTP request with the time now, or with a wrong
+// originate timestamp if spoof is set.
+func sntpServer(t *testing.T, now time.Time, spoof bool) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		var req [48]byte
+		_, addr, err := conn.ReadFrom(req[:])
+		if err != nil {
+			return
+		}
+
+		var resp [48]byte
+		resp[0] = 4<<3 | 4
+		resp[1] = 2
+		copy(resp[24:32], req[40:48])
+		if spoof {
+			resp[24] ^= 1
+		}
+		sec := uint32(now.Unix() + ntpEpochOffset)
+		frac := uint32((uint64(now.Nanosecond()) << 32) / 1e9)
+		for _, off := range []int{32, 40} {
+			binary.BigEndian.PutUint32(resp[off:], sec)
+			binary.BigEndian.PutUint32(resp[off+4:], frac)
+		}
+		conn.WriteTo(resp[:], addr)
+	}()
+
+	return conn.LocalAddr().String()
+}
+
+func TestSNTPTimeSource(t *testing.T) {
+	now := time.Date(2022, 3, 1, 3, 0, 0, 250000000, time.UTC)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	r, err := NewSNTPTimeSource(sntpServer(t, now, false)).Sample(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := r.Time.Sub(now); d < -time.Microsecond || time.Microsecond < d {
+		t.Errorf("got %s, want %s", r.Time, now)
+	}
+
+	_, err = NewSNTPTimeSource(sntpServer(t, now, true)).Sample(ctx)
+	if !errors.Is(err, errInvalidSNTP) {
+		t.Errorf("spoofed response: got %v", err)
+	}
+}
+
+func TestParseTimeSource(t *testing.T) {
+	tests := []struct {
+		s    string
+		name string
+	}{
+		{"ntp://time.example.com", "ntp://time.example.com:123"},
+		{"ntp://time.example.com:1123", "ntp://time.example.com:1123"},
+		{"https://example.com/", "https://example.com/"},
+	}
+	for _, tt := range tests {
+		src, err := ParseTimeSource(tt.s)
+		if err != nil {
+			t.Errorf("%s: %v", tt.s, err)
+			continue
+		}
+		if src.Name() != tt.name {
+			t.Errorf("%s: name %q, want %q", tt.s, src.Name(), tt.name)
+		}
+	}
+
+	for _, s := range []string{"ftp://example.com", "ntp://", "time.example.com"} {
+		if _, err := ParseTimeSource(s); err == nil {
+			t.Errorf("%s: expected an error", s)
+		}
+	}
+}
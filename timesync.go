@@ -0,0 +1,255 @@
+package uotp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/RyuaNerin/uotp/protocol"
+)
+
+const (
+	// defaultSyncSamples is how many times SyncTime asks the server.
+	defaultSyncSamples = 4
+
+	// maxSyncRTT drops samples that took longer than this.
+	maxSyncRTT = 5 * time.Second
+
+	// maxSyncSkew is the largest difference accepted between the local clock
+	// and the server's, as instants. It allows for a clock set to the wrong
+	// time zone. The offset itself can be days larger, since the scale of
+	// the server has 30-day months and 365-day years.
+	maxSyncSkew = 26 * time.Hour
+
+	// serverTimeResolution is the resolution of the time sent by the server.
+	serverTimeResolution = time.Second
+)
+
+// TokenStep is how long a token is valid.
+const TokenStep = 10 * time.Second
+
+var ErrImplausibleOffset = errors.New("implausible time offset")
+
+// SyncResult is the outcome of a time synchronization.
+type SyncResult struct {
+	// Offset is added to the local clock to get the server's.
+	Offset time.Duration
+	// RTT is the round trip time of the sample the offset was taken from.
+	RTT time.Duration
+	// Uncertainty bounds the error of Offset: the server's clock is within
+	// Offset ± Uncertainty of the local one.
+	Uncertainty time.Duration
+	// Confidence, from 0 to 1, is how likely tokens are to be in the right
+	// step. It drops with the uncertainty and when samples disagree.
+	Confidence float64
+	// Samples is how many samples were accepted.
+	Samples int
+	// Rejected is how many samples were dropped as too slow or implausible.
+	Rejected int
+
+	// Source is the name of the time source used, ServerTimeSource unless
+	// the server failed.
+	Source string
+	// Authoritative is false when the offset comes from a source that is
+	// not trusted like the server.
+	Authoritative bool
+	// At is when the offset was measured, by the local clock.
+	At time.Time
+}
+
+// WithSyncSamples sets how many samples SyncTime takes. The default is 4.
+func WithSyncSamples(n int) Option {
+	return func(u *uotp) {
+		u.syncSamples = n
+	}
+}
+
+// otpClock returns t in the time scale of the server, with the precision of t.
+func otpClock(t time.Time) time.Duration {
+	return time.Duration(otpTime(t))*time.Second + time.Duration(t.Nanosecond())
+}
+
+// serverInstant returns the instant of server, a time in the scale of the
+// server. The 31st of a month has the same time as the 1st of the next one;
+// the day nearest to near is chosen.
+func serverInstant(server time.Duration, near time.Time) time.Time {
+	secs := int64(server / time.Second)
+	year, secs := secs/31536000, secs%31536000
+	month, secs := secs/2592000, secs%2592000
+	day, secs := secs/86400, secs%86400
+	if month == 12 {
+		// December 31st
+		month, day = 11, day+30
+	}
+
+	t := time.Date(2000+int(year), time.Month(month+1), int(day+1), 0, 0, 0, 0, serverLocation).
+		Add(time.Duration(secs)*time.Second + server%time.Second)
+	if alt := t.AddDate(0, 0, -1); otpClock(alt) == otpClock(t) && absDuration(near.Sub(alt)) < absDuration(near.Sub(t)) {
+		return alt
+	}
+	return t
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+// syncSample is one request of the server's time. All times but sentAt are
+// in the scale of the server.
+type syncSample struct {
+	sentAt     time.Time
+	sent       time.Duration
+	received   time.Duration
+	server     time.Duration
+	resolution time.Duration
+}
+
+func (s *syncSample) rtt() time.Duration {
+	return s.received - s.sent
+}
+
+// offset assumes the server read its clock halfway through the round trip,
+// and halfway through the resolution of the time it reports, which is
+// truncated.
+func (s *syncSample) offset() time.Duration {
+	mid := s.sent + s.rtt()/2
+	return s.server + s.resolution/2 - mid
+}
+
+// skew is how far the server's clock is ahead of the local one, as instants
+// rather than in the scale of the server.
+func (s *syncSample) skew() time.Duration {
+	mid := s.sentAt.Add(s.rtt() / 2)
+	return serverInstant(s.server+s.resolution/2, mid).Sub(mid)
+}
+
+// uncertainty is half of the range the offset can be in.
+func (s *syncSample) uncertainty() time.Duration {
+	return (s.rtt() + s.resolution) / 2
+}
+
+func (u *uotp) sampleTime(ctx context.Context) (*syncSample, error) {
+	req := newPacket(protocol.OpTime)
+
+	// The round trip starts once connected, so that the handshake does not
+	// count. Transports that do not report it are timed from the start.
+	sentAt := u.now()
+	ctx = protocol.WithClientTrace(ctx, &protocol.ClientTrace{
+		Connected: func() { sentAt = u.now() },
+	})
+	err := req.Send(ctx, u.client)
+	receivedAt := u.now()
+	if err != nil {
+		return nil, err
+	}
+
+	return &syncSample{
+		sentAt:     sentAt,
+		sent:       otpClock(sentAt),
+		received:   otpClock(receivedAt),
+		server:     time.Duration(req.payload.(*payloadTime).Time) * time.Second,
+		resolution: serverTimeResolution,
+	}, nil
+}
+
+// syncTime asks the server, then the fallback sources in order, until one of
+// them gives a plausible offset.
+func (u *uotp) syncTime(ctx context.Context) (*SyncResult, error) {
+	r, err := u.syncWith(ctx, serverSampler{})
+	if err == nil || len(u.timeSources) == 0 || ctx.Err() != nil {
+		return r, err
+	}
+
+	for _, src := range u.timeSources {
+		r, fallbackErr := u.syncWith(ctx, sourceSampler{src})
+		if fallbackErr == nil {
+			return r, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+	}
+	return nil, fmt.Errorf("%w, and %d fallback time sources failed", err, len(u.timeSources))
+}
+
+// syncWith takes several samples from src and keeps the one with the lowest
+// round trip time, which is the least affected by latency.
+func (u *uotp) syncWith(ctx context.Context, src timeSampler) (*SyncResult, error) {
+	n := u.syncSamples
+	if n <= 0 {
+		n = defaultSyncSamples
+	}
+
+	var samples []*syncSample
+	var firstErr error
+	rejected := 0
+	for i := 0; i < n; i++ {
+		s, err := src.sample(ctx, u)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+
+		// The skew is checked rather than the offset, which also holds the
+		// difference between the local time zone and the server's.
+		if skew := s.skew(); s.rtt() < 0 || s.rtt() > maxSyncRTT || skew < -maxSyncSkew || maxSyncSkew < skew {
+			rejected++
+			continue
+		}
+		samples = append(samples, s)
+	}
+
+	if len(samples) == 0 {
+		if firstErr != nil {
+			return nil, firstErr
+		}
+		return nil, fmt.Errorf("%w: %d samples rejected", ErrImplausibleOffset, rejected)
+	}
+
+	sort.SliceStable(samples, func(i, j int) bool {
+		return samples[i].rtt() < samples[j].rtt()
+	})
+	best := samples[0]
+
+	r := &SyncResult{
+		Offset:        best.offset(),
+		RTT:           best.rtt(),
+		Uncertainty:   best.uncertainty(),
+		Rejected:      rejected,
+		Source:        src.name(),
+		Authoritative: src.authoritative(),
+		At:            u.now(),
+	}
+
+	// Every sample bounds the offset, so the bounds of two good samples
+	// overlap. The ones that do not overlap the chosen one are outliers.
+	agree := 0
+	for _, s := range samples {
+		d := s.offset() - r.Offset
+		if max := s.uncertainty() + r.Uncertainty; -max <= d && d <= max {
+			agree++
+		}
+	}
+	if agree*2 <= len(samples) && len(samples) > 1 {
+		return nil, fmt.Errorf("%w: only %d of %d samples agree", ErrImplausibleOffset, agree, len(samples))
+	}
+	r.Samples = len(samples)
+
+	r.Confidence = 1 - float64(2*r.Uncertainty)/float64(TokenStep)
+	if r.Confidence < 0 {
+		r.Confidence = 0
+	}
+	r.Confidence *= float64(agree) / float64(len(samples))
+
+	return r, nil
+}
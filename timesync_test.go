@@ -0,0 +1,174 @@
+package uotp
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSyncTime(t *testing.T) {
+	const offset = 3*time.Hour + 3700*time.Millisecond
+
+	for _, start := range []time.Duration{0, 250 * time.Millisecond, 600 * time.Millisecond, 999 * time.Millisecond} {
+		clock := time.Date(2022, 3, 1, 12, 0, 0, 0, serverLocation).Add(start)
+		u := newTestUOTP(t, &fakeServer{
+			t:     t,
+			clock: &clock,
+			skew:  constantSkew(offset),
+			rtts:  []time.Duration{300 * time.Millisecond, 40 * time.Millisecond, 900 * time.Millisecond, 100 * time.Millisecond},
+		})
+
+		r, err := u.SyncTime(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.RTT != 40*time.Millisecond || r.Samples != 4 || r.Rejected != 0 || r.Source != ServerTimeSource || !r.Authoritative {
+			t.Errorf("start %s: got %+v", start, r)
+		}
+		if d := r.Offset - offset; d < -r.Uncertainty || r.Uncertainty < d {
+			t.Errorf("start %s: offset %s, want %s ± %s", start, r.Offset, offset, r.Uncertainty)
+		}
+		if r.Confidence <= 0.8 || 1 < r.Confidence {
+			t.Errorf("start %s: confidence %f", start, r.Confidence)
+		}
+		if u.offset() != r.Offset {
+			t.Errorf("start %s: offset is not used", start)
+		}
+	}
+}
+
+func TestSyncTimeImplausible(t *testing.T) {
+	clock := time.Date(2022, 3, 1, 12, 0, 0, 0, serverLocation)
+	u := newTestUOTP(t, &fakeServer{t: t, clock: &clock, skew: constantSkew(30 * time.Hour), rtts: []time.Duration{50 * time.Millisecond}})
+
+	_, err := u.SyncTime(context.Background())
+	if !errors.Is(err, ErrImplausibleOffset) {
+		t.Errorf("got %v, want ErrImplausibleOffset", err)
+	}
+	if u.offset() != 0 {
+		t.Error("offset changed")
+	}
+}
+
+// TestSyncTimeZone checks that the offset is accepted on hosts outside the
+// zone of the server, where it is days long when the server is in the next
+// month or year: its months are 30 days long and its years 365 days long.
+func TestSyncTimeZone(t *testing.T) {
+	tests := []struct {
+		clock  time.Time
+		offset time.Duration
+	}{
+		{time.Date(2026, 2, 28, 20, 0, 0, 0, time.UTC), 57 * time.Hour},
+		{time.Date(2024, 2, 29, 20, 0, 0, 0, time.UTC), 33 * time.Hour},
+		{time.Date(2026, 12, 31, 20, 0, 0, 0, time.UTC), 105 * time.Hour},
+		// The 31st and the 1st of the next month have the same time.
+		{time.Date(2026, 1, 31, 14, 0, 0, 0, time.UTC), 9 * time.Hour},
+	}
+	for _, tt := range tests {
+		clock := tt.clock
+		u := newTestUOTP(t, &fakeServer{t: t, clock: &clock, rtts: []time.Duration{20 * time.Millisecond}})
+
+		r, err := u.SyncTime(context.Background())
+		if err != nil {
+			t.Errorf("%s: %v", tt.clock, err)
+			continue
+		}
+		if d := r.Offset - tt.offset; d < -r.Uncertainty || r.Uncertainty < d {
+			t.Errorf("%s: offset %s, want %s ± %s", tt.clock, r.Offset, tt.offset, r.Uncertainty)
+		}
+	}
+}
+
+// TestSyncTimeHandshake checks that the time taken to connect is not counted
+// in the round trip.
+func TestSyncTimeHandshake(t *testing.T) {
+	clock := time.Date(2022, 3, 1, 12, 0, 0, 0, serverLocation)
+	u := newTestUOTP(t, &fakeServer{t: t, clock: &clock, connect: time.Second, rtts: []time.Duration{40 * time.Millisecond}})
+
+	r, err := u.SyncTime(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.RTT != 40*time.Millisecond {
+		t.Errorf("RTT %s, want 40ms", r.RTT)
+	}
+}
+
+func TestSyncTimeOutlier(t *testing.T) {
+	clock := time.Date(2022, 3, 1, 12, 0, 0, 0, serverLocation)
+
+	// The fastest sample is off by 20 seconds.
+	u := newTestUOTP(t, &fakeServer{
+		t:     t,
+		clock: &clock,
+		skew: func(i int) time.Duration {
+			if i == 1 {
+				return 20 * time.Second
+			}
+			return 0
+		},
+		rtts: []time.Duration{100 * time.Millisecond, 10 * time.Millisecond, 100 * time.Millisecond, 100 * time.Millisecond},
+	})
+
+	_, err := u.SyncTime(context.Background())
+	if !errors.Is(err, ErrImplausibleOffset) {
+		t.Errorf("got %v, want ErrImplausibleOffset", err)
+	}
+}
+
+func TestSyncTimeError(t *testing.T) {
+	errDown := errors.New("down")
+	u := newTestUOTP(t, &fakeServer{t: t, err: errDown})
+
+	_, err := u.SyncTime(context.Background())
+	if !errors.Is(err, errDown) {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestAccountTimeOffset(t *testing.T) {
+	account := testAccount
+	account.TimeDiff = -5
+	u, err := New(&account)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := u.GetAccount(); got.TimeDiff != -5 || got.TimeOffset != -5*time.Second {
+		t.Errorf("got %d, %s", got.TimeDiff, got.TimeOffset)
+	}
+
+	account.TimeOffset = 2600 * time.Millisecond
+	u, err = New(&account)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := u.GetAccount(); got.TimeDiff != 3 || got.TimeOffset != 2600*time.Millisecond {
+		t.Errorf("got %d, %s", got.TimeDiff, got.TimeOffset)
+	}
+}
+
+func TestToken(t *testing.T) {
+	clock := time.Date(2024, 5, 1, 12, 0, 3, 250e6, time.UTC)
+	u := newTestUOTP(t, &fakeServer{t: t, clock: &clock, err: errOffline})
+	u.setOffset(4 * time.Second)
+
+	token := u.Token()
+	if token.Remaining != 2750*time.Millisecond || !token.Expires.Equal(clock.Add(token.Remaining)) {
+		t.Errorf("remaining %s, expires %s", token.Remaining, token.Expires)
+	}
+	if token.Code != u.GenerateToken() || strings.ReplaceAll(token.Code, "-", "") != token.Digits {
+		t.Errorf("code %q, digits %q, generated %q", token.Code, token.Digits, u.GenerateToken())
+	}
+
+	// The token changes when it expires, and not before.
+	clock = token.Expires.Add(-time.Millisecond)
+	if u.Token().Digits != token.Digits {
+		t.Error("token changed before it expired")
+	}
+	clock = token.Expires
+	if next := u.Token(); next.Digits == token.Digits || next.Remaining != TokenStep {
+		t.Errorf("next token %+v", next)
+	}
+}
@@ -0,0 +1,225 @@
+package uotp
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/RyuaNerin/uotp/internal/record"
+	"github.com/RyuaNerin/uotp/protocol"
+)
+
+var testAccount = Account{
+	ID:           "99eb850e6bd360717a98bbb72b8b30220603995d2b02aa837fcbf5b0460d7614",
+	OID:          "630981781",
+	Seed:         "upAErT/8zjSYgIq2pl5Miorq9rs=",
+	SerialNumber: "1784-5365-6261",
+	Device:       &DefaultDeviceProfiles[0],
+}
+
+// fakeServer answers every request the library makes, without a network.
+// Its zero value, with t set, is a server whose clock agrees with the real
+// one and whose history is empty.
+type fakeServer struct {
+	t testing.TB
+
+	// clock, if set, is the local clock of the UOTP made by newTestUOTP.
+	// Time requests advance it by their round trip time.
+	clock *time.Time
+	// rtts are the round trip times of time requests, taken in turn.
+	rtts []time.Duration
+	// connect is the time taken to connect before a time request, reported
+	// with protocol.ClientTrace.
+	connect time.Duration
+	// skew is how far the server's clock is ahead of the local one at the
+	// i-th request.
+	skew func(i int) time.Duration
+	// block, if set, holds time requests until it is closed. A held request
+	// is sent to entered, if set and ready.
+	block   chan struct{}
+	entered chan struct{}
+
+	// history is served perPage entries at a time, 10 if perPage is zero.
+	// onHistory is called with each history request.
+	history   []HistoryEntry
+	perPage   int
+	onHistory func(page int, period HistoryPeriod)
+
+	// err, if set, fails every request.
+	err error
+
+	requests int32
+}
+
+// errOffline is the error of a fakeServer that is down.
+var errOffline = errors.New("down")
+
+func constantSkew(d time.Duration) func(int) time.Duration {
+	return func(int) time.Duration { return d }
+}
+
+// count returns how many requests the server got.
+func (f *fakeServer) count() int {
+	return int(atomic.LoadInt32(&f.requests))
+}
+
+func (f *fakeServer) Do(ctx context.Context, op protocol.OpCode, payload []byte, enc *protocol.Encryption) (*protocol.Frame, error) {
+	i := int(atomic.AddInt32(&f.requests, 1)) - 1
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	resp := &protocol.Frame{
+		Status: protocol.StatusOK,
+		OpCode: op,
+	}
+
+	switch op {
+	case protocol.OpTime:
+		if f.block != nil {
+			select {
+			case f.entered <- struct{}{}:
+			default:
+			}
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-f.block:
+			}
+		}
+		resp.Payload = f.time(ctx, i)
+
+	case protocol.OpIssue:
+		e := record.NewEncoder(payloadIssueLayout, nil)
+		e.String("178453656261")
+		e.Uint(630981781)
+		e.Hex(make([]byte, 20))
+		e.String(testAccount.ID)
+		e.String("info")
+		resp.Payload = e.Bytes()
+
+	case protocol.OpUseHistory:
+		resp.Payload = f.historyPage(payload)
+
+	case protocol.OpInformation:
+		e := record.NewEncoder(payloadInfomationLayout, nil)
+		e.Uint(630981781)
+		e.String("ba9004ad3ffcce3498808ab6a65e4c8a8aaef6bb")
+		e.String("partner")
+		resp.Payload = e.Bytes()
+
+	case protocol.OpResetErrorCount:
+
+	default:
+		f.t.Fatalf("unexpected opcode %d", op)
+	}
+	return resp, nil
+}
+
+// time answers the i-th request, in the scale of the server.
+func (f *fakeServer) time(ctx context.Context, i int) []byte {
+	var skew time.Duration
+	if f.skew != nil {
+		skew = f.skew(i)
+	}
+
+	var server time.Duration
+	if f.clock == nil {
+		server = otpClock(time.Now().In(serverLocation).Add(skew))
+	} else {
+		*f.clock = f.clock.Add(f.connect)
+		if trace := protocol.ContextClientTrace(ctx); trace != nil && trace.Connected != nil {
+			trace.Connected()
+		}
+
+		var rtt time.Duration
+		if len(f.rtts) > 0 {
+			rtt = f.rtts[i%len(f.rtts)]
+		}
+		*f.clock = f.clock.Add(rtt / 2)
+		server = otpClock(f.clock.In(serverLocation).Add(skew))
+		*f.clock = f.clock.Add(rtt - rtt/2)
+	}
+
+	data := make([]byte, 4)
+	binary.BigEndian.PutUint32(data, uint32(server/time.Second))
+	return data
+}
+
+// historyPage answers a history request with a page of f.history.
+func (f *fakeServer) historyPage(payload []byte) []byte {
+	d := record.NewDecoder(historyRequestLayout, payload[commonHeaderLayout.Size():])
+	page := d.Int()
+	period := HistoryPeriod(d.Int())
+	if err := d.Err(); err != nil {
+		f.t.Fatal(err)
+	}
+	if f.onHistory != nil {
+		f.onHistory(page, period)
+	}
+
+	perPage := f.perPage
+	if perPage == 0 {
+		perPage = 10
+	}
+	h := History{
+		PeriodStart: time.Date(2022, 1, 1, 0, 0, 0, 0, serverLocation),
+		PeriodEnd:   time.Date(2022, 3, 31, 0, 0, 0, 0, serverLocation),
+		PageTotal:   (len(f.history) + perPage - 1) / perPage,
+		PageCurrent: page,
+	}
+	if start := (page - 1) * perPage; start < len(f.history) {
+		end := start + perPage
+		if end > len(f.history) {
+			end = len(f.history)
+		}
+		h.Entries = f.history[start:end]
+	}
+	return encodeHistory(f.t, &h)
+}
+
+// newTestUOTP returns a UOTP of testAccount talking to server. It uses the
+// clock of server, if it has one.
+func newTestUOTP(t testing.TB, server *fakeServer, opts ...Option) *uotp {
+	account := testAccount
+	o, err := New(&account, append([]Option{WithClient(server)}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u := o.(*uotp)
+	if server.clock != nil {
+		u.now = func() time.Time { return *server.clock }
+	}
+	return u
+}
+
+// encodeHistory encodes h as the server does.
+func encodeHistory(t testing.TB, h *History) []byte {
+	e := record.NewEncoder(historyLayout, nil)
+	e.String(h.PeriodStart.Format("2006-01-02"))
+	e.String(h.PeriodEnd.Format("2006-01-02"))
+	e.Uint(uint64(h.PageTotal))
+	e.Uint(uint64(h.PageCurrent))
+	e.Uint(uint64(len(h.Entries)))
+	data := e.Bytes()
+	if err := e.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, entry := range h.Entries {
+		e := record.NewEncoder(historyEntryLayout, data)
+		e.String(entry.At.Format("2006-01-0215:04:05"))
+		e.EUCKR(entry.Type)
+		e.EUCKR(entry.Name)
+		data = e.Bytes()
+		if err := e.Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return data
+}
@@ -6,12 +6,19 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"math/rand"
 	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/RyuaNerin/uotp/protocol"
 )
 
 var (
 	ErrInvalidAccount = errors.New("invalid account")
 	ErrInvalidPage    = errors.New("page must be 1 or greater.")
+	ErrInvalidPeriod  = errors.New("invalid history period")
 )
 
 type UOTP interface {
@@ -19,33 +26,123 @@ type UOTP interface {
 	GetAccount() Account
 
 	GenerateToken() string
-	SyncTime(ctx context.Context) error
+	Token() Token
+	SyncTime(ctx context.Context) (*SyncResult, error)
 	Issue(ctx context.Context) error
 	ResetError(ctx context.Context) error
 	GetHistory(ctx context.Context, page int) (*History, error)
+	GetHistoryPeriod(ctx context.Context, page int, period HistoryPeriod) (*History, error)
+	HistoryAll(ctx context.Context, period HistoryPeriod) *HistoryIterator
 	ResetErrorCount(ctx context.Context) error
+	GetInformation(ctx context.Context) (*Information, error)
 }
 
+// uotp is safe for concurrent use. mu guards the account, which Issue
+// replaces; the offset is atomic so tokens never wait for a synchronization.
 type uotp struct {
+	mu sync.RWMutex
+
 	id           string
 	oid          uint64
 	seed         []byte
 	serialNumber string
-	timeDiff     int
+	timeOffset   int64 // time.Duration, accessed atomically
+	device       *DeviceProfile
+
+	// timeSource and timeUncertainty describe the last synchronization.
+	timeSource      string
+	timeUncertainty time.Duration
+	timeFallback    bool
+	timeSyncedAt    time.Time
+
+	rand        *rand.Rand
+	client      Transport
+	syncSamples int
+	timeSources []TimeSource
+	now         func() time.Time
+
+	// issues counts the calls to Issue, so that a synchronization started
+	// before one is not applied to the new account.
+	issues uint64
+
+	syncMu   sync.Mutex
+	syncCall *syncCall
+}
+
+// syncCall is a SyncTime in flight, shared by the calls made meanwhile.
+type syncCall struct {
+	done chan struct{}
+	// issues is uotp.issues when the call started.
+	issues uint64
+	r      *SyncResult
+	err    error
 }
 
+// errSyncStale is the error of a syncCall that Issue made stale.
+var errSyncStale = errors.New("synchronization started before Issue")
+
 type Account struct {
 	ID           string `json:"id"`
 	OID          string `json:"oid"`
 	Seed         string `json:"seed"`
 	SerialNumber string `json:"serial_number"`
-	TimeDiff     int    `json:"time_diff"`
+	// TimeDiff is the offset to the server's clock in seconds.
+	TimeDiff int `json:"time_diff"`
+	// TimeOffset is the same offset with its full precision. It is used
+	// instead of TimeDiff when it is not zero.
+	TimeOffset time.Duration `json:"time_offset,omitempty"`
+	// TimeSource is the name of the source the offset was measured with,
+	// and TimeUncertainty its uncertainty. TimeFallback is true when the
+	// source is not trusted like the server. TimeSyncedAt is when it was
+	// measured, nil if it never was.
+	TimeSource      string         `json:"time_source,omitempty"`
+	TimeUncertainty time.Duration  `json:"time_uncertainty,omitempty"`
+	TimeFallback    bool           `json:"time_fallback,omitempty"`
+	TimeSyncedAt    *time.Time     `json:"time_synced_at,omitempty"`
+	Device          *DeviceProfile `json:"device,omitempty"`
+}
+
+// Option configures an instance created by New.
+type Option func(u *uotp)
+
+// WithRand sets the random source used to pick a device profile
+// when the account does not have one.
+func WithRand(r *rand.Rand) Option {
+	return func(u *uotp) {
+		u.rand = r
+	}
+}
+
+// Transport sends raw requests to the server. *protocol.Client implements it.
+type Transport interface {
+	Do(ctx context.Context, op protocol.OpCode, payload []byte, enc *protocol.Encryption) (*protocol.Frame, error)
 }
 
-func New(account *Account) (UOTP, error) {
+// WithClient sets the client used to talk to the server.
+func WithClient(client Transport) Option {
+	return func(u *uotp) {
+		u.client = client
+	}
+}
+
+// WithDeviceProfile overrides the device profile stored in the account.
+func WithDeviceProfile(device DeviceProfile) Option {
+	return func(u *uotp) {
+		u.device = &device
+	}
+}
+
+func New(account *Account, opts ...Option) (UOTP, error) {
 	var err error
 
-	o := &uotp{}
+	o := &uotp{
+		client: protocol.DefaultClient,
+		now:    time.Now,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+
 	if account != nil {
 		o.id = account.ID
 		o.oid, err = strconv.ParseUint(account.OID, 10, 64)
@@ -57,27 +154,90 @@ func New(account *Account) (UOTP, error) {
 			return nil, ErrInvalidAccount
 		}
 		o.serialNumber = fmt.Sprint(account.SerialNumber)
-		o.timeDiff = account.TimeDiff
+		if account.TimeOffset != 0 {
+			o.setOffset(account.TimeOffset)
+		} else {
+			o.setOffset(time.Duration(account.TimeDiff) * time.Second)
+		}
+		o.timeSource = account.TimeSource
+		o.timeUncertainty = account.TimeUncertainty
+		o.timeFallback = account.TimeFallback
+		if account.TimeSyncedAt != nil {
+			o.timeSyncedAt = *account.TimeSyncedAt
+		}
+
+		if o.device == nil && account.Device != nil {
+			device := *account.Device
+			o.device = &device
+		}
+	}
+
+	if o.device == nil {
+		device := RandomDeviceProfile(o.rand)
+		o.device = &device
+	}
+	if err := o.device.validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidAccount, err)
 	}
 
 	return o, err
 }
 
 func (u *uotp) GetSerialNumber() string {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+
 	return fmt.Sprint(u.serialNumber)
 }
 func (u *uotp) GetAccount() Account {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+
+	device := *u.device
+	offset := u.offset()
+	var syncedAt *time.Time
+	if !u.timeSyncedAt.IsZero() {
+		t := u.timeSyncedAt
+		syncedAt = &t
+	}
 	return Account{
-		ID:           u.id,
-		OID:          strconv.FormatUint(u.oid, 10),
-		Seed:         base64.StdEncoding.EncodeToString(u.seed),
-		SerialNumber: fmt.Sprint(u.serialNumber),
-		TimeDiff:     u.timeDiff,
+		ID:              u.id,
+		OID:             strconv.FormatUint(u.oid, 10),
+		Seed:            base64.StdEncoding.EncodeToString(u.seed),
+		SerialNumber:    fmt.Sprint(u.serialNumber),
+		TimeDiff:        int(offset.Round(time.Second) / time.Second),
+		TimeOffset:      offset,
+		TimeSource:      u.timeSource,
+		TimeUncertainty: u.timeUncertainty,
+		TimeFallback:    u.timeFallback,
+		TimeSyncedAt:    syncedAt,
+		Device:          &device,
 	}
 }
 
+// offset returns the offset to the server's clock. It may be called while
+// another goroutine synchronizes the time.
+func (u *uotp) offset() time.Duration {
+	return time.Duration(atomic.LoadInt64(&u.timeOffset))
+}
+
+func (u *uotp) setOffset(d time.Duration) {
+	atomic.StoreInt64(&u.timeOffset, int64(d))
+}
+
+// clock returns the server's time, in its scale.
+func (u *uotp) clock() time.Duration {
+	return otpClock(u.now()) + u.offset()
+}
+
+// generateToken must be called with otp.mu held.
 func (otp *uotp) generateToken() string {
-	now := uint32(int(otpNow()) + otp.timeDiff)
+	return otp.generateTokenAt(otp.clock())
+}
+
+// generateTokenAt must be called with otp.mu held.
+func (otp *uotp) generateTokenAt(clock time.Duration) string {
+	now := uint32(clock / time.Second)
 
 	time := now / 10
 	oid := otp.oid
@@ -142,75 +302,230 @@ func (otp *uotp) generateToken() string {
 }
 
 func (otp *uotp) GenerateToken() string {
+	otp.mu.RLock()
+	defer otp.mu.RUnlock()
+
 	return humanize(otp.generateToken(), "-", 3, 2)
 }
 
-func (u *uotp) SyncTime(ctx context.Context) error {
-	now := int(otpNow())
+// Token is a generated token with its validity.
+type Token struct {
+	// Code is the token as shown by the app, e.g. "123-4567".
+	Code string
+	// Digits is Code without the separator.
+	Digits string
+	// Remaining is how long the token stays valid.
+	Remaining time.Duration
+	// Expires is the local time the token stops being valid.
+	Expires time.Time
+}
 
-	req := newPacket(opCodeTime)
-	resp, err := req.Send(ctx)
-	if err != nil {
-		return err
+func (otp *uotp) Token() Token {
+	otp.mu.RLock()
+	defer otp.mu.RUnlock()
+
+	now := otp.now()
+	clock := otpClock(now) + otp.offset()
+	digits := otp.generateTokenAt(clock)
+	remaining := TokenStep - clock%TokenStep
+
+	return Token{
+		Code:      humanize(digits, "-", 3, 2),
+		Digits:    digits,
+		Remaining: remaining,
+		Expires:   now.Add(remaining),
 	}
+}
 
-	u.timeDiff = resp.payload.(*payloadTime).Time - now
-	return nil
+// SyncTime measures the offset to the server's clock and uses it for the
+// tokens generated afterwards.
+//
+// Calls made while another is in flight wait for it and share its result.
+// If the context of the first call is canceled, the others start over. A
+// synchronization that was in flight when Issue reset the offset is not
+// applied, and every call waiting for it starts over.
+func (u *uotp) SyncTime(ctx context.Context) (*SyncResult, error) {
+	for {
+		u.syncMu.Lock()
+		c := u.syncCall
+		if c == nil {
+			c = u.startSync(ctx)
+		} else {
+			u.syncMu.Unlock()
+
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-c.done:
+			}
+
+			if c.err != nil && (errors.Is(c.err, context.Canceled) || errors.Is(c.err, context.DeadlineExceeded)) {
+				continue
+			}
+		}
+
+		if c.err == errSyncStale {
+			continue
+		}
+		if c.err != nil {
+			return nil, c.err
+		}
+		r := *c.r
+		return &r, nil
+	}
+}
+
+// startSync runs a synchronization as the call the others wait for. u.syncMu
+// must be held, and is released.
+func (u *uotp) startSync(ctx context.Context) *syncCall {
+	u.mu.RLock()
+	c := &syncCall{
+		done:   make(chan struct{}),
+		issues: u.issues,
+	}
+	u.mu.RUnlock()
+	u.syncCall = c
+	u.syncMu.Unlock()
+
+	c.r, c.err = u.syncTime(ctx)
+	if c.err == nil {
+		u.mu.Lock()
+		if u.issues == c.issues {
+			u.setSync(c.r)
+		} else {
+			c.err = errSyncStale
+		}
+		u.mu.Unlock()
+	}
+
+	u.syncMu.Lock()
+	u.syncCall = nil
+	u.syncMu.Unlock()
+	close(c.done)
+	return c
+}
+
+// applySync uses the offset measured by a synchronization.
+func (u *uotp) applySync(r *SyncResult) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.setSync(r)
+}
+
+// setSync is applySync with u.mu held.
+func (u *uotp) setSync(r *SyncResult) {
+	u.setOffset(r.Offset)
+	u.timeSource = r.Source
+	u.timeUncertainty = r.Uncertainty
+	u.timeFallback = !r.Authoritative
+	u.timeSyncedAt = r.At
+}
+
+// newAuthPacket returns a request authenticated as the current account.
+func (u *uotp) newAuthPacket(opcode protocol.OpCode) *packet {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+
+	req := newPacket(opcode)
+	req.oid = u.oid
+	req.device = u.device
+	req.encryption = protocol.NewEncryption(s2b(u.id), u.generateToken())
+	return req
 }
 
 func (u *uotp) Issue(ctx context.Context) error {
-	req := newPacket(opCodeIssue)
-	resp, err := req.Send(ctx)
+	sharedKey, err := protocol.NewSharedKey()
 	if err != nil {
 		return err
 	}
 
-	params := resp.payload.(*payloadIssue)
+	req := newPacket(protocol.OpIssue)
+	req.device = u.device
+	req.encryption = protocol.NewEncryption(sharedKey, "")
+	err = req.Send(ctx, u.client)
+	if err != nil {
+		return err
+	}
+
+	params := req.payload.(*payloadIssue)
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
 
 	u.id = params.userHash
 	u.oid = params.oid
 	u.seed = params.seed
 	u.serialNumber = humanize(params.serialNumber, "-", 4, -1)
-	u.timeDiff = 0
+	u.issues++
+	u.setOffset(0)
+	u.timeSource = ""
+	u.timeUncertainty = 0
+	u.timeFallback = false
+	u.timeSyncedAt = time.Time{}
 
 	return nil
 }
 
 func (u *uotp) ResetError(ctx context.Context) error {
-	req := newPacket(opCodeResetErrorCount)
-	req.oid = u.oid
-	req.setEncryptionInfo(s2b(u.id), u.generateToken())
+	req := u.newAuthPacket(protocol.OpResetErrorCount)
 
-	_, err := req.Send(ctx)
-	return err
+	return req.Send(ctx, u.client)
 }
 
 func (u *uotp) GetHistory(ctx context.Context, page int) (*History, error) {
+	return u.GetHistoryPeriod(ctx, page, HistoryPeriodThreeMonths)
+}
+
+func (u *uotp) GetHistoryPeriod(ctx context.Context, page int, period HistoryPeriod) (*History, error) {
 	if page < 1 {
 		return nil, ErrInvalidPage
 	}
+	if !period.valid() {
+		return nil, ErrInvalidPeriod
+	}
 
-	req := newPacket(opCodeUseHistory)
-	req.oid = u.oid
-	req.setEncryptionInfo(s2b(u.id), u.generateToken())
+	req := u.newAuthPacket(protocol.OpUseHistory)
 
 	params := req.payload.(*History)
 	params.requestPage = page
-	params.requestPeriod = 3
+	params.requestPeriod = period
 
-	resp, err := req.Send(ctx)
+	err := req.Send(ctx, u.client)
 	if err != nil {
 		return nil, err
 	}
 
-	return resp.payload.(*History), nil
+	return params, nil
 }
 
-func (u *uotp) ResetErrorCount(ctx context.Context) (err error) {
-	req := newPacket(opCodeResetErrorCount)
-	req.oid = u.oid
-	req.setEncryptionInfo(s2b(u.id), u.generateToken())
+func (u *uotp) HistoryAll(ctx context.Context, period HistoryPeriod) *HistoryIterator {
+	return &HistoryIterator{
+		ctx:    ctx,
+		u:      u,
+		period: period,
+	}
+}
+
+func (u *uotp) ResetErrorCount(ctx context.Context) error {
+	req := u.newAuthPacket(protocol.OpResetErrorCount)
+
+	return req.Send(ctx, u.client)
+}
+
+// GetInformation asks the server about the account. The seed the server
+// sends back is not returned.
+func (u *uotp) GetInformation(ctx context.Context) (*Information, error) {
+	req := u.newAuthPacket(protocol.OpInformation)
+
+	err := req.Send(ctx, u.client)
+	if err != nil {
+		return nil, err
+	}
 
-	_, err = req.Send(ctx)
-	return
+	p := req.payload.(*payloadInfomation)
+	return &Information{
+		OID:     p.oid,
+		Partner: p.partner,
+	}, nil
 }
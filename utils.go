@@ -9,9 +9,9 @@ import (
 	"unsafe"
 )
 
-func otpNow() uint32 {
-	now := time.Now()
-
+// otpTime returns t in seconds in the time scale of the server, which counts
+// years of 365 days and months of 30 days since 2000.
+func otpTime(now time.Time) uint32 {
 	return uint32(
 		(now.Year()-2000)*31536000 +
 			(int(now.Month())-1)*2592000 +